@@ -26,11 +26,67 @@ import (
 type ProviderTargetType string
 
 const (
-	ProviderTargetTypeGCP ProviderTargetType = "gcp"
+	ProviderTargetTypeGCP        ProviderTargetType = "gcp"
+	ProviderTargetTypeAWS        ProviderTargetType = "aws"
+	ProviderTargetTypeAzure      ProviderTargetType = "azure"
+	ProviderTargetTypeVault      ProviderTargetType = "vault"
+	ProviderTargetTypeGeneric    ProviderTargetType = "generic"
+	ProviderTargetTypeAlibaba    ProviderTargetType = "alibaba"
+	ProviderTargetTypeMinIO      ProviderTargetType = "minio"
+	ProviderTargetTypeKubernetes ProviderTargetType = "kubernetes"
+	ProviderTargetTypeGKENative  ProviderTargetType = "gke-native"
+	ProviderTargetTypeAKSNative  ProviderTargetType = "aks-native"
 )
 
 var AllProviderTargetTypes = []ProviderTargetType{
 	ProviderTargetTypeGCP,
+	ProviderTargetTypeAWS,
+	ProviderTargetTypeAzure,
+	ProviderTargetTypeVault,
+	ProviderTargetTypeGeneric,
+	ProviderTargetTypeAlibaba,
+	ProviderTargetTypeMinIO,
+	ProviderTargetTypeKubernetes,
+	ProviderTargetTypeGKENative,
+	ProviderTargetTypeAKSNative,
+}
+
+type AWSSTSRegionalEndpoints string
+
+const (
+	AWSSTSRegionalEndpointsRegional AWSSTSRegionalEndpoints = "regional"
+	AWSSTSRegionalEndpointsLegacy   AWSSTSRegionalEndpoints = "legacy"
+)
+
+var AllAWSSTSRegionalEndpoints = []AWSSTSRegionalEndpoints{
+	AWSSTSRegionalEndpointsRegional,
+	AWSSTSRegionalEndpointsLegacy,
+}
+
+type AzureCloud string
+
+const (
+	AzureCloudPublic       AzureCloud = "public"
+	AzureCloudChina        AzureCloud = "china"
+	AzureCloudUSGovernment AzureCloud = "usgovernment"
+)
+
+var AllAzureClouds = []AzureCloud{
+	AzureCloudPublic,
+	AzureCloudChina,
+	AzureCloudUSGovernment,
+}
+
+// AuthorityHost returns the Microsoft Entra authority host of the cloud.
+func (c AzureCloud) AuthorityHost() string {
+	switch c {
+	case AzureCloudChina:
+		return "https://login.chinacloudapi.cn/"
+	case AzureCloudUSGovernment:
+		return "https://login.microsoftonline.us/"
+	default:
+		return "https://login.microsoftonline.com/"
+	}
 }
 
 // ProviderSpec defines the desired state of Provider
@@ -41,17 +97,289 @@ type ProviderSpec struct {
 	// +kubebuilder:validation:Required
 	Target ProviderTargetType `json:"target"`
 
-	// +kubebuilder:validation:Required
-	PoolID string `json:"poolID"`
+	// PoolID, ProviderID and Project are required when target is gcp.
+	// Project is optional for gke-native, where it is used to report the principal.
+
+	// +optional
+	PoolID string `json:"poolID,omitempty"`
 
 	// +kubebuilder:default="global"
 	Location string `json:"location"`
 
+	// +optional
+	ProviderID string `json:"providerID,omitempty"`
+
+	// +optional
+	Project Project `json:"project,omitempty"`
+
+	// UniverseDomain is the domain of the Google Cloud universe. Defaults to googleapis.com.
+	// +optional
+	UniverseDomain string `json:"universeDomain,omitempty"`
+
+	// TokenURL overrides the Security Token Service endpoint, e.g. a Private Service Connect endpoint.
+	// +optional
+	TokenURL string `json:"tokenURL,omitempty"`
+
+	// ImpersonationHost overrides the host of the IAM Service Account Credentials API.
+	// +optional
+	ImpersonationHost string `json:"impersonationHost,omitempty"`
+
+	// Audience is the audience of the projected token, which must be one of the allowed audiences
+	// of the pool provider. Defaults to https://iam.<universeDomain>/ followed by the full name of the pool provider.
+	// It only changes the token: the audience field of the credential configuration is always
+	// //iam.<universeDomain>/ followed by the full name of the pool provider, since STS requires the
+	// pool provider there regardless of the audience of the token.
+	// +optional
+	Audience string `json:"audience,omitempty"`
+
+	// AttributeMapping mirrors the attribute mapping of the pool provider. It maps google.subject,
+	// google.groups and attribute.NAME to CEL expressions over the token claims in assertion.
+	// Defaults to google.subject=assertion.sub.
+	// +optional
+	AttributeMapping map[string]string `json:"attributeMapping,omitempty"`
+
+	// AttributeCondition mirrors the attribute condition of the pool provider. The controller
+	// reports a Fail condition on WorkloadIdentities whose tokens would not satisfy it.
+	// +optional
+	AttributeCondition string `json:"attributeCondition,omitempty"`
+
+	// AWS is required when target is aws.
+	// +optional
+	AWS *AWSProvider `json:"aws,omitempty"`
+
+	// Azure is required when target is azure. It is optional for aks-native, where the tenant
+	// defaults to the one of the cluster.
+	// +optional
+	Azure *AzureProvider `json:"azure,omitempty"`
+
+	// Vault is required when target is vault.
+	// +optional
+	Vault *VaultProvider `json:"vault,omitempty"`
+
+	// Generic is required when target is generic.
+	// +optional
+	Generic *GenericProvider `json:"generic,omitempty"`
+
+	// Alibaba is required when target is alibaba.
+	// +optional
+	Alibaba *AlibabaProvider `json:"alibaba,omitempty"`
+
+	// MinIO is required when target is minio.
+	// +optional
+	MinIO *MinIOProvider `json:"minio,omitempty"`
+
+	// Kubernetes is required when target is kubernetes.
+	// +optional
+	Kubernetes *KubernetesProvider `json:"kubernetes,omitempty"`
+
+	// CredentialSource is where the subject token comes from. Defaults to a projected service account token.
+	// +optional
+	CredentialSource *CredentialSource `json:"credentialSource,omitempty"`
+}
+
+type CredentialSourceType string
+
+const (
+	CredentialSourceTypeServiceAccountToken CredentialSourceType = "serviceAccountToken"
+	CredentialSourceTypeSPIFFE              CredentialSourceType = "spiffe"
+	CredentialSourceTypeX509                CredentialSourceType = "x509"
+)
+
+var AllCredentialSourceTypes = []CredentialSourceType{
+	CredentialSourceTypeServiceAccountToken,
+	CredentialSourceTypeSPIFFE,
+	CredentialSourceTypeX509,
+}
+
+type CredentialSource struct {
+	// +kubebuilder:validation:Enum=serviceAccountToken;spiffe;x509
+	// +kubebuilder:default="serviceAccountToken"
+	Type CredentialSourceType `json:"type,omitempty"`
+
+	// SPIFFE is used when type is spiffe.
+	// +optional
+	SPIFFE *SPIFFECredentialSource `json:"spiffe,omitempty"`
+
+	// X509 is used when type is x509. It is only supported by the gcp target.
+	// +optional
+	X509 *X509CredentialSource `json:"x509,omitempty"`
+}
+
+// SPIFFECredentialSource uses JWT-SVIDs fetched from the SPIFFE Workload API
+// mounted by the SPIFFE CSI driver. A spiffe-helper sidecar writes the
+// JWT-SVIDs where the projected tokens would otherwise be.
+type SPIFFECredentialSource struct {
+	// +kubebuilder:default="csi.spiffe.io"
+	Driver string `json:"driver,omitempty"`
+
+	// SocketPath is the file name of the Workload API socket inside the CSI volume.
+	// +kubebuilder:default="spire-agent.sock"
+	SocketPath string `json:"socketPath,omitempty"`
+
+	// +kubebuilder:default="ghcr.io/spiffe/spiffe-helper:0.8.0"
+	HelperImage string `json:"helperImage,omitempty"`
+}
+
+// X509CredentialSource authenticates with a client certificate instead of a
+// token. Either IssuerRef or SecretName must be set.
+type X509CredentialSource struct {
+	// IssuerRef is the cert-manager issuer of the Certificate created for each WorkloadIdentity.
+	// +optional
+	IssuerRef *X509IssuerReference `json:"issuerRef,omitempty"`
+
+	// SecretName is an existing kubernetes.io/tls Secret in the namespace of the WorkloadIdentity.
+	// +optional
+	SecretName string `json:"secretName,omitempty"`
+
+	// Duration is the lifetime of the created Certificate, e.g. 24h.
+	// +optional
+	Duration *metav1.Duration `json:"duration,omitempty"`
+}
+
+type X509IssuerReference struct {
 	// +kubebuilder:validation:Required
-	ProviderID string `json:"providerID"`
+	Name string `json:"name"`
+
+	// +kubebuilder:default="Issuer"
+	Kind string `json:"kind,omitempty"`
+
+	// +kubebuilder:default="cert-manager.io"
+	Group string `json:"group,omitempty"`
+}
+
+// AWSProvider configures AssumeRoleWithWebIdentity for the aws target.
+type AWSProvider struct {
+	// +kubebuilder:validation:Required
+	RoleARN string `json:"roleARN"`
+
+	// +kubebuilder:validation:Required
+	Region string `json:"region"`
+
+	// +kubebuilder:validation:Enum=regional;legacy
+	// +kubebuilder:default="regional"
+	STSRegionalEndpoints AWSSTSRegionalEndpoints `json:"stsRegionalEndpoints,omitempty"`
+
+	// +optional
+	SessionName string `json:"sessionName,omitempty"`
+}
+
+// AzureProvider configures workload identity federation for the azure target.
+type AzureProvider struct {
+	// +kubebuilder:validation:Required
+	TenantID string `json:"tenantID"`
+
+	// +kubebuilder:validation:Enum=public;china;usgovernment
+	// +kubebuilder:default="public"
+	Cloud AzureCloud `json:"cloud,omitempty"`
+}
+
+// VaultProvider configures the Vault JWT auth method for the vault target.
+type VaultProvider struct {
+	// Address is the address of the Vault server, e.g. https://vault.example.com:8200.
+	// +kubebuilder:validation:Required
+	Address string `json:"address"`
+
+	// AuthMountPath is the path the JWT auth method is mounted at.
+	// +kubebuilder:default="jwt"
+	AuthMountPath string `json:"authMountPath,omitempty"`
 
 	// +kubebuilder:validation:Required
-	Project Project `json:"project"`
+	Role string `json:"role"`
+
+	// Audience is the audience of the projected token. It must match the bound_audiences of the role.
+	// +kubebuilder:default="vault"
+	Audience string `json:"audience,omitempty"`
+
+	// Agent injects a Vault Agent sidecar that authenticates with the rendered configuration.
+	// +optional
+	Agent *VaultAgent `json:"agent,omitempty"`
+}
+
+type VaultAgent struct {
+	// +kubebuilder:default="hashicorp/vault:1.17"
+	Image string `json:"image,omitempty"`
+}
+
+// GenericProvider only projects service account tokens for applications that
+// exchange them on their own.
+type GenericProvider struct {
+	// Tokens are projected into MountPath, one file per audience.
+	// +kubebuilder:validation:MinItems=1
+	Tokens []GenericToken `json:"tokens"`
+
+	// +kubebuilder:validation:Minimum=600
+	// +kubebuilder:default=3600
+	ExpirationSeconds int64 `json:"expirationSeconds,omitempty"`
+
+	// +kubebuilder:default="/var/run/kwimount-token/"
+	MountPath string `json:"mountPath,omitempty"`
+}
+
+type GenericToken struct {
+	// +kubebuilder:validation:Required
+	Audience string `json:"audience"`
+
+	// Path is the file name of the token relative to MountPath.
+	// +kubebuilder:validation:Required
+	Path string `json:"path"`
+
+	// EnvName is the name of an environment variable set to the absolute path of the token.
+	// +optional
+	EnvName string `json:"envName,omitempty"`
+}
+
+// AlibabaProvider configures RAM Roles for Service Accounts (RRSA) for the alibaba target.
+type AlibabaProvider struct {
+	// RoleARN is the ARN of the RAM role to assume, e.g. acs:ram::123456789012:role/my-role.
+	// +kubebuilder:validation:Required
+	RoleARN string `json:"roleARN"`
+
+	// OIDCProviderARN is the ARN of the OIDC identity provider, e.g. acs:ram::123456789012:oidc-provider/my-cluster.
+	// +kubebuilder:validation:Required
+	OIDCProviderARN string `json:"oidcProviderARN"`
+}
+
+// MinIOProvider configures AssumeRoleWithWebIdentity against the MinIO STS API
+// for the minio target.
+type MinIOProvider struct {
+	// Endpoint is the URL of the MinIO server serving both the STS and S3 APIs.
+	// +kubebuilder:validation:Required
+	Endpoint string `json:"endpoint"`
+
+	// RoleARN is the ARN MinIO printed for the OpenID configuration with a role policy.
+	// With claim based policies MinIO ignores it, but the AWS SDKs still require a value.
+	// Session policies are not supported, since the AWS SDKs cannot send one from the shared config;
+	// the permissions come from the role policy or the policy claim configured in MinIO.
+	// +kubebuilder:validation:Required
+	RoleARN string `json:"roleARN"`
+
+	// Audience must match the client_id of the MinIO OpenID configuration.
+	// +kubebuilder:validation:Required
+	Audience string `json:"audience"`
+
+	// +kubebuilder:default="us-east-1"
+	Region string `json:"region,omitempty"`
+}
+
+// KubernetesProvider configures access to a remote Kubernetes API server that
+// accepts the service account tokens of this cluster through structured
+// authentication configuration.
+type KubernetesProvider struct {
+	// Server is the URL of the remote API server.
+	// +kubebuilder:validation:Required
+	Server string `json:"server"`
+
+	// CertificateAuthority is the PEM encoded CA bundle of the remote API server.
+	// +optional
+	CertificateAuthority string `json:"certificateAuthority,omitempty"`
+
+	// Audience must be one of the audiences of the JWT authenticator of the remote API server.
+	// +kubebuilder:validation:Required
+	Audience string `json:"audience"`
+
+	// Namespace is the default namespace of the rendered kubeconfig context.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
 }
 
 type Project struct {
@@ -19,6 +19,7 @@ package v1alpha1
 import (
 	"fmt"
 	"slices"
+	"strings"
 
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/validation/field"
@@ -49,6 +50,35 @@ func (r *Provider) Default() {
 	if r.Spec.Location == "" {
 		r.Spec.Location = "global"
 	}
+	if r.Spec.AWS != nil && r.Spec.AWS.STSRegionalEndpoints == "" {
+		r.Spec.AWS.STSRegionalEndpoints = AWSSTSRegionalEndpointsRegional
+	}
+	if r.Spec.Azure != nil && r.Spec.Azure.Cloud == "" {
+		r.Spec.Azure.Cloud = AzureCloudPublic
+	}
+	if r.Spec.Vault != nil {
+		if r.Spec.Vault.AuthMountPath == "" {
+			r.Spec.Vault.AuthMountPath = "jwt"
+		}
+		if r.Spec.Vault.Audience == "" {
+			r.Spec.Vault.Audience = "vault"
+		}
+		if r.Spec.Vault.Agent != nil && r.Spec.Vault.Agent.Image == "" {
+			r.Spec.Vault.Agent.Image = "hashicorp/vault:1.17"
+		}
+	}
+	if r.Spec.Generic != nil {
+		if r.Spec.Generic.ExpirationSeconds == 0 {
+			r.Spec.Generic.ExpirationSeconds = 3600
+		}
+		if r.Spec.Generic.MountPath == "" {
+			r.Spec.Generic.MountPath = "/var/run/kwimount-token/"
+		}
+	}
+	if r.Spec.MinIO != nil && r.Spec.MinIO.Region == "" {
+		r.Spec.MinIO.Region = "us-east-1"
+	}
+	r.Spec.CredentialSource.Default()
 }
 
 // TODO(user): change verbs to "verbs=create;update;delete" if you want to enable deletion validation.
@@ -83,17 +113,94 @@ func (r *Provider) validate() (admission.Warnings, error) {
 	if !slices.Contains(AllProviderTargetTypes, r.Spec.Target) {
 		return nil, field.Invalid(field.NewPath("spec", "target"), r.Spec.Target, fmt.Sprintf("target must be one of %v", AllProviderTargetTypes))
 	}
-	if r.Spec.PoolID == "" {
-		return nil, field.Invalid(field.NewPath("spec", "poolID"), r.Spec.PoolID, "poolID cannot be empty")
+	if err := r.Spec.CredentialSource.validate(field.NewPath("spec", "credentialSource")); err != nil {
+		return nil, err
 	}
-	if r.Spec.ProviderID == "" {
-		return nil, field.Invalid(field.NewPath("spec", "providerID"), r.Spec.ProviderID, "providerID cannot be empty")
+	if r.Spec.CredentialSource != nil && r.Spec.CredentialSource.Type == CredentialSourceTypeX509 && r.Spec.Target != ProviderTargetTypeGCP {
+		return nil, field.Invalid(field.NewPath("spec", "credentialSource", "type"), r.Spec.CredentialSource.Type, "x509 is only supported by the gcp target")
 	}
-	if r.Spec.Project.Number == "" {
-		return nil, field.Invalid(field.NewPath("spec", "project", "number"), r.Spec.Project.Number, "project number cannot be empty")
+	validator, ok := targetValidators[r.Spec.Target]
+	if !ok {
+		return nil, field.Invalid(field.NewPath("spec", "target"), r.Spec.Target, "target has no registered backend")
 	}
-	if r.Spec.Project.Name == "" {
-		return nil, field.Invalid(field.NewPath("spec", "project", "id"), r.Spec.Project.Name, "project id cannot be empty")
+	return nil, validator(&r.Spec)
+}
+
+var targetValidators = map[ProviderTargetType]func(spec *ProviderSpec) error{}
+
+// RegisterTargetValidator registers the validation rules of a provider target.
+// Backends call it on registration so the webhook does not need to know about
+// every target.
+func RegisterTargetValidator(target ProviderTargetType, validator func(spec *ProviderSpec) error) {
+	targetValidators[target] = validator
+}
+
+// ValidateSingleLine rejects a value containing line breaks. Values are
+// rendered into INI files such as the AWS shared config and the gcloud
+// configuration, where a line break would inject extra keys.
+func ValidateSingleLine(path *field.Path, value string) *field.Error {
+	if strings.ContainsAny(value, "\r\n") {
+		return field.Invalid(path, value, "must be a single line")
 	}
-	return nil, nil
+	return nil
+}
+
+// Default fills in the defaults of the credential source. It is shared by the
+// Provider and WorkloadIdentity webhooks.
+func (c *CredentialSource) Default() {
+	if c == nil {
+		return
+	}
+	if c.Type == "" {
+		c.Type = CredentialSourceTypeServiceAccountToken
+	}
+	if c.Type == CredentialSourceTypeSPIFFE && c.SPIFFE == nil {
+		c.SPIFFE = &SPIFFECredentialSource{}
+	}
+	if c.SPIFFE != nil {
+		if c.SPIFFE.Driver == "" {
+			c.SPIFFE.Driver = "csi.spiffe.io"
+		}
+		if c.SPIFFE.SocketPath == "" {
+			c.SPIFFE.SocketPath = "spire-agent.sock"
+		}
+		if c.SPIFFE.HelperImage == "" {
+			c.SPIFFE.HelperImage = "ghcr.io/spiffe/spiffe-helper:0.8.0"
+		}
+	}
+	if c.X509 != nil && c.X509.IssuerRef != nil {
+		if c.X509.IssuerRef.Kind == "" {
+			c.X509.IssuerRef.Kind = "Issuer"
+		}
+		if c.X509.IssuerRef.Group == "" {
+			c.X509.IssuerRef.Group = "cert-manager.io"
+		}
+	}
+}
+
+func (c *CredentialSource) validate(path *field.Path) error {
+	if c == nil {
+		return nil
+	}
+	if c.Type != "" && !slices.Contains(AllCredentialSourceTypes, c.Type) {
+		return field.Invalid(path.Child("type"), c.Type, fmt.Sprintf("type must be one of %v", AllCredentialSourceTypes))
+	}
+	if c.SPIFFE != nil && strings.Contains(c.SPIFFE.SocketPath, "/") {
+		return field.Invalid(path.Child("spiffe", "socketPath"), c.SPIFFE.SocketPath, "socketPath must be a file name inside the CSI volume")
+	}
+	if c.Type == CredentialSourceTypeX509 {
+		if c.X509 == nil {
+			return field.Required(path.Child("x509"), "x509 cannot be empty when type is x509")
+		}
+		if (c.X509.IssuerRef == nil) == (c.X509.SecretName == "") {
+			return field.Invalid(path.Child("x509"), c.X509, "exactly one of issuerRef and secretName must be set")
+		}
+		if c.X509.IssuerRef != nil && c.X509.IssuerRef.Name == "" {
+			return field.Required(path.Child("x509", "issuerRef", "name"), "issuer name cannot be empty")
+		}
+		if c.X509.SecretName != "" && c.X509.Duration != nil {
+			return field.Invalid(path.Child("x509", "duration"), c.X509.Duration, "duration can only be set with issuerRef")
+		}
+	}
+	return nil
 }
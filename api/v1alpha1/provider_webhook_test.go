@@ -14,12 +14,16 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package v1alpha1
+package v1alpha1_test
 
 import (
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	. "github.com/piny940/kwimount/api/v1alpha1"
+	// Register the backends so that the target specific validation runs.
+	_ "github.com/piny940/kwimount/internal/backend"
 )
 
 var _ = Describe("Provider Webhook", func() {
@@ -43,6 +47,20 @@ var _ = Describe("Provider Webhook", func() {
 			provider.Default()
 			Expect(provider.Spec.Location).To(Equal("global"))
 		})
+
+		It("Should default the AWS STS regional endpoints", func() {
+			provider := &Provider{
+				Spec: ProviderSpec{
+					Target: "aws",
+					AWS: &AWSProvider{
+						RoleARN: "arn:aws:iam::123456789012:role/my-role",
+						Region:  "ap-northeast-1",
+					},
+				},
+			}
+			provider.Default()
+			Expect(provider.Spec.AWS.STSRegionalEndpoints).To(Equal(AWSSTSRegionalEndpointsRegional))
+		})
 	})
 
 	Context("When creating Provider under Validating Webhook", func() {
@@ -99,6 +117,98 @@ var _ = Describe("Provider Webhook", func() {
 					},
 				},
 			}),
+			Entry("Empty AWS", &Provider{
+				Spec: ProviderSpec{
+					Target: "aws",
+				},
+			}),
+			Entry("Empty AWS RoleARN", &Provider{
+				Spec: ProviderSpec{
+					Target: "aws",
+					AWS: &AWSProvider{
+						Region: "ap-northeast-1",
+					},
+				},
+			}),
+			Entry("Empty AWS Region", &Provider{
+				Spec: ProviderSpec{
+					Target: "aws",
+					AWS: &AWSProvider{
+						RoleARN: "arn:aws:iam::123456789012:role/my-role",
+					},
+				},
+			}),
+			Entry("Invalid AWS STSRegionalEndpoints", &Provider{
+				Spec: ProviderSpec{
+					Target: "aws",
+					AWS: &AWSProvider{
+						RoleARN:              "arn:aws:iam::123456789012:role/my-role",
+						Region:               "ap-northeast-1",
+						STSRegionalEndpoints: "global",
+					},
+				},
+			}),
+			Entry("Empty Azure", &Provider{
+				Spec: ProviderSpec{
+					Target: "azure",
+				},
+			}),
+			Entry("Empty Azure TenantID", &Provider{
+				Spec: ProviderSpec{
+					Target: "azure",
+					Azure:  &AzureProvider{},
+				},
+			}),
+			Entry("Invalid Azure Cloud", &Provider{
+				Spec: ProviderSpec{
+					Target: "azure",
+					Azure: &AzureProvider{
+						TenantID: "00000000-0000-0000-0000-000000000000",
+						Cloud:    "germany",
+					},
+				},
+			}),
+			Entry("Empty Vault", &Provider{
+				Spec: ProviderSpec{
+					Target: "vault",
+				},
+			}),
+			Entry("Empty Vault Role", &Provider{
+				Spec: ProviderSpec{
+					Target: "vault",
+					Vault: &VaultProvider{
+						Address: "https://vault.example.com:8200",
+					},
+				},
+			}),
+			Entry("Empty Alibaba", &Provider{
+				Spec: ProviderSpec{
+					Target: "alibaba",
+				},
+			}),
+			Entry("Invalid Alibaba RoleARN", &Provider{
+				Spec: ProviderSpec{
+					Target: "alibaba",
+					Alibaba: &AlibabaProvider{
+						RoleARN:         "arn:aws:iam::123456789012:role/my-role",
+						OIDCProviderARN: "acs:ram::123456789012:oidc-provider/my-cluster",
+					},
+				},
+			}),
+			Entry("Invalid CredentialSource Type", &Provider{
+				Spec: ProviderSpec{
+					Target:     "gcp",
+					PoolID:     "pool-1",
+					ProviderID: "gcp-provider-1",
+					Project: Project{
+						Name:   "my-project",
+						Number: "12345",
+					},
+					CredentialSource: &CredentialSource{
+						Type: "file",
+					},
+				},
+			}),
 		)
 
 		It("Should admit if all required fields are provided", func() {
@@ -117,6 +227,21 @@ var _ = Describe("Provider Webhook", func() {
 			Expect(err).NotTo(HaveOccurred())
 			Expect(warns).To(BeNil())
 		})
+
+		It("Should admit an aws provider without gcp fields", func() {
+			provider := &Provider{
+				Spec: ProviderSpec{
+					Target: "aws",
+					AWS: &AWSProvider{
+						RoleARN: "arn:aws:iam::123456789012:role/my-role",
+						Region:  "ap-northeast-1",
+					},
+				},
+			}
+			warns, err := provider.ValidateCreate()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warns).To(BeNil())
+		})
 	})
 
 })
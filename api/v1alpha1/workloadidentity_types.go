@@ -29,9 +29,99 @@ type WorkloadIdentitySpec struct {
 	// Important: Run "make" to regenerate code after modifying this file
 
 	// +kubebuilder:validation:Required
-	Deployment           string                   `json:"deployment"`
-	TargetServiceAccount string                   `json:"targetServiceAccount"`
+	Deployment string `json:"deployment"`
+	// TargetServiceAccount is the GCP service account to impersonate. It is required when the provider target is gcp.
+	// +optional
+	TargetServiceAccount string                   `json:"targetServiceAccount,omitempty"`
 	Provider             WorkloadIdentityProvider `json:"provider"`
+	// GCP configures the credentials of the gcp provider target.
+	// +optional
+	GCP *WorkloadIdentityGCP `json:"gcp,omitempty"`
+	// AWS configures the credentials of the aws provider target.
+	// +optional
+	AWS *WorkloadIdentityAWS `json:"aws,omitempty"`
+	// Azure is required when the provider target is azure.
+	// +optional
+	Azure *WorkloadIdentityAzure `json:"azure,omitempty"`
+	// CredentialSource overrides the credential source of the Provider.
+	// +optional
+	CredentialSource *CredentialSource `json:"credentialSource,omitempty"`
+	// DisableAutoRollout stops annotating the pod template with a hash of the credential config,
+	// so changes of the config are only picked up when the pods are restarted.
+	// +optional
+	DisableAutoRollout bool `json:"disableAutoRollout,omitempty"`
+}
+
+type GCPAccessMode string
+
+const (
+	// GCPAccessModeImpersonation impersonates TargetServiceAccount.
+	GCPAccessModeImpersonation GCPAccessMode = "impersonation"
+	// GCPAccessModeDirect uses the federated identity itself, which must be granted IAM roles directly.
+	GCPAccessModeDirect GCPAccessMode = "direct"
+)
+
+var AllGCPAccessModes = []GCPAccessMode{
+	GCPAccessModeImpersonation,
+	GCPAccessModeDirect,
+}
+
+type WorkloadIdentityGCP struct {
+	// +kubebuilder:validation:Enum=impersonation;direct
+	// +kubebuilder:default="impersonation"
+	AccessMode GCPAccessMode `json:"accessMode,omitempty"`
+	// TokenLifetimeSeconds is the lifetime of the access token issued by the impersonation.
+	// The service account must be allowed to issue tokens longer than an hour by the organization policy.
+	// It cannot be combined with Delegates.
+	// +kubebuilder:validation:Minimum=600
+	// +kubebuilder:validation:Maximum=43200
+	// +optional
+	TokenLifetimeSeconds *int32 `json:"tokenLifetimeSeconds,omitempty"`
+	// Delegates is the ordered chain of service account emails to impersonate before TargetServiceAccount.
+	// Each service account must be able to create tokens for the next one.
+	// +optional
+	Delegates []string `json:"delegates,omitempty"`
+	// QuotaProjectID is the project used for quota and billing of the API requests.
+	// +kubebuilder:validation:Pattern=`^[a-z][a-z0-9-]{4,28}[a-z0-9]$`
+	// +optional
+	QuotaProjectID string `json:"quotaProjectID,omitempty"`
+	// InjectProjectEnv injects GOOGLE_CLOUD_PROJECT and CLOUDSDK_CORE_PROJECT with the project of the Provider into each container.
+	// +optional
+	InjectProjectEnv bool `json:"injectProjectEnv,omitempty"`
+	// InjectGcloudConfig configures the gcloud, gsutil and bq CLIs to use the same credentials.
+	// +optional
+	InjectGcloudConfig bool `json:"injectGcloudConfig,omitempty"`
+}
+
+// WorkloadIdentityAWS grants additional roles through an AWS shared config file.
+type WorkloadIdentityAWS struct {
+	// Profiles are rendered next to the default profile, which assumes the role of the Provider.
+	// +optional
+	Profiles []AWSProfile `json:"profiles,omitempty"`
+	// DefaultProfile is the profile selected by AWS_PROFILE.
+	// +kubebuilder:default="default"
+	DefaultProfile string `json:"defaultProfile,omitempty"`
+}
+
+type AWSProfile struct {
+	// +kubebuilder:validation:Pattern=`^[A-Za-z0-9_.-]+$`
+	Name string `json:"name"`
+	// +kubebuilder:validation:Required
+	RoleARN string `json:"roleARN"`
+	// SourceProfile chains the role from another profile instead of assuming it with the web identity token.
+	// +optional
+	SourceProfile string `json:"sourceProfile,omitempty"`
+	// DurationSeconds is the session duration of the role. Chained roles are limited to an hour by AWS.
+	// +kubebuilder:validation:Minimum=900
+	// +kubebuilder:validation:Maximum=43200
+	// +optional
+	DurationSeconds *int32 `json:"durationSeconds,omitempty"`
+}
+
+type WorkloadIdentityAzure struct {
+	// ClientID is the client ID of the Microsoft Entra application or managed identity.
+	// +kubebuilder:validation:Required
+	ClientID string `json:"clientID"`
 }
 
 type WorkloadIdentityProvider struct {
@@ -46,11 +136,29 @@ type WorkloadIdentityStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 	Conditions []metav1.Condition `json:"condition"`
+	// Principal is the federated identity that must be granted IAM roles in the gcp direct access mode.
+	// +optional
+	Principal string `json:"principal,omitempty"`
+	// AppliedDeployment is the deployment the credentials were last injected into.
+	// When spec.deployment changes, the previous deployment is cleaned up.
+	// +optional
+	AppliedDeployment string `json:"appliedDeployment,omitempty"`
+	// ProviderGeneration is the generation of the Provider the injected credentials were rendered from.
+	// +optional
+	ProviderGeneration int64 `json:"providerGeneration,omitempty"`
+	// ServiceAccount is the service account kwimount applied annotations and labels to.
+	// A service account is managed by at most one WorkloadIdentity.
+	// +optional
+	ServiceAccount string `json:"serviceAccount,omitempty"`
+	// Certificate is the cert-manager Certificate created for the x509 credential source.
+	// +optional
+	Certificate string `json:"certificate,omitempty"`
 }
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
 // +kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Done\")].status"
+// +kubebuilder:printcolumn:name="Principal",type="string",JSONPath=".status.principal",priority=1
 
 // WorkloadIdentity is the Schema for the workloadidentities API
 type WorkloadIdentity struct {
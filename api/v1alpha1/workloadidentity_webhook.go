@@ -17,6 +17,10 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"fmt"
+	"regexp"
+	"strings"
+
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/validation/field"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -28,6 +32,24 @@ import (
 // log is for logging in this package.
 var workloadidentitylog = logf.Log.WithName("workloadidentity-resource")
 
+var gcpProjectIDRegexp = regexp.MustCompile(`^[a-z][a-z0-9-]{4,28}[a-z0-9]$`)
+
+var awsProfileNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+const (
+	GCP_MIN_TOKEN_LIFETIME_SECONDS = 600
+	GCP_MAX_TOKEN_LIFETIME_SECONDS = 43200
+
+	AWS_DEFAULT_PROFILE              = "default"
+	AWS_MIN_DURATION_SECONDS         = 900
+	AWS_MAX_DURATION_SECONDS         = 43200
+	AWS_MAX_CHAINED_DURATION_SECONDS = 3600
+)
+
+// RejectDeploymentRetargeting makes the webhook reject changes of
+// spec.deployment instead of only warning about them.
+var RejectDeploymentRetargeting = false
+
 // SetupWebhookWithManager will setup the manager to manage the webhooks
 func (r *WorkloadIdentity) SetupWebhookWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewWebhookManagedBy(mgr).
@@ -48,6 +70,13 @@ func (r *WorkloadIdentity) Default() {
 	if r.Spec.Provider.Namespace == "" {
 		r.Spec.Provider.Namespace = r.Namespace
 	}
+	r.Spec.CredentialSource.Default()
+	if r.Spec.GCP != nil && r.Spec.GCP.AccessMode == "" {
+		r.Spec.GCP.AccessMode = GCPAccessModeImpersonation
+	}
+	if r.Spec.AWS != nil && r.Spec.AWS.DefaultProfile == "" {
+		r.Spec.AWS.DefaultProfile = AWS_DEFAULT_PROFILE
+	}
 }
 
 // TODO(user): change verbs to "verbs=create;update;delete" if you want to enable deletion validation.
@@ -68,7 +97,21 @@ func (r *WorkloadIdentity) ValidateCreate() (admission.Warnings, error) {
 func (r *WorkloadIdentity) ValidateUpdate(old runtime.Object) (admission.Warnings, error) {
 	workloadidentitylog.Info("validate update", "name", r.Name)
 
-	return r.validate()
+	warnings, err := r.validate()
+	if err != nil {
+		return warnings, err
+	}
+	oldWI, ok := old.(*WorkloadIdentity)
+	if !ok || oldWI.Spec.Deployment == r.Spec.Deployment {
+		return warnings, nil
+	}
+	path := field.NewPath("spec", "deployment")
+	if RejectDeploymentRetargeting {
+		return warnings, field.Forbidden(path, "retargeting is disabled; create a new WorkloadIdentity for the deployment instead")
+	}
+	warnings = append(warnings, fmt.Sprintf("%s changed from %q to %q: credentials will be removed from the previous deployment",
+		path, oldWI.Spec.Deployment, r.Spec.Deployment))
+	return warnings, nil
 }
 
 // ValidateDelete implements webhook.Validator so a webhook will be registered for the type
@@ -82,11 +125,131 @@ func (r *WorkloadIdentity) validate() (admission.Warnings, error) {
 	if r.Spec.Deployment == "" {
 		return nil, field.Invalid(field.NewPath("spec", "deployment"), r.Spec.Deployment, "deployment cannot be empty")
 	}
-	if r.Spec.TargetServiceAccount == "" {
-		return nil, field.Invalid(field.NewPath("spec", "targetServiceAccount"), r.Spec.TargetServiceAccount, "targetServiceAccount cannot be empty")
-	}
 	if r.Spec.Provider.Name == "" {
 		return nil, field.Invalid(field.NewPath("spec", "provider", "name"), r.Spec.Provider.Name, "provider name cannot be empty")
 	}
+	if err := r.Spec.CredentialSource.validate(field.NewPath("spec", "credentialSource")); err != nil {
+		return nil, err
+	}
+	if err := r.validateGCP(); err != nil {
+		return nil, err
+	}
+	if err := r.validateAWS(); err != nil {
+		return nil, err
+	}
+	if r.Spec.Azure != nil && r.Spec.Azure.ClientID == "" {
+		return nil, field.Invalid(field.NewPath("spec", "azure", "clientID"), r.Spec.Azure.ClientID, "clientID cannot be empty")
+	}
 	return nil, nil
 }
+
+func (r *WorkloadIdentity) validateGCP() error {
+	if r.Spec.GCP == nil {
+		return nil
+	}
+	path := field.NewPath("spec", "gcp")
+	switch r.Spec.GCP.AccessMode {
+	case "", GCPAccessModeImpersonation:
+		if r.Spec.TargetServiceAccount == "" {
+			return field.Invalid(field.NewPath("spec", "targetServiceAccount"), r.Spec.TargetServiceAccount, "targetServiceAccount cannot be empty in the impersonation access mode")
+		}
+	case GCPAccessModeDirect:
+		if r.Spec.TargetServiceAccount != "" {
+			return field.Invalid(field.NewPath("spec", "targetServiceAccount"), r.Spec.TargetServiceAccount, "targetServiceAccount must be empty in the direct access mode")
+		}
+		if r.Spec.GCP.TokenLifetimeSeconds != nil {
+			return field.Invalid(path.Child("tokenLifetimeSeconds"), *r.Spec.GCP.TokenLifetimeSeconds, "tokenLifetimeSeconds cannot be set in the direct access mode")
+		}
+		if len(r.Spec.GCP.Delegates) > 0 {
+			return field.Invalid(path.Child("delegates"), r.Spec.GCP.Delegates, "delegates cannot be set in the direct access mode")
+		}
+	default:
+		return field.Invalid(path.Child("accessMode"), r.Spec.GCP.AccessMode, fmt.Sprintf("accessMode must be one of %v", AllGCPAccessModes))
+	}
+	if lifetime := r.Spec.GCP.TokenLifetimeSeconds; lifetime != nil {
+		if *lifetime < GCP_MIN_TOKEN_LIFETIME_SECONDS || *lifetime > GCP_MAX_TOKEN_LIFETIME_SECONDS {
+			return field.Invalid(path.Child("tokenLifetimeSeconds"), *lifetime,
+				fmt.Sprintf("tokenLifetimeSeconds must be between %d and %d", GCP_MIN_TOKEN_LIFETIME_SECONDS, GCP_MAX_TOKEN_LIFETIME_SECONDS))
+		}
+		if len(r.Spec.GCP.Delegates) > 0 {
+			return field.Invalid(path.Child("tokenLifetimeSeconds"), *lifetime, "tokenLifetimeSeconds cannot be combined with delegates")
+		}
+	}
+	if r.Spec.GCP.QuotaProjectID != "" && !gcpProjectIDRegexp.MatchString(r.Spec.GCP.QuotaProjectID) {
+		return field.Invalid(path.Child("quotaProjectID"), r.Spec.GCP.QuotaProjectID, "quotaProjectID must be a valid project ID")
+	}
+	seen := make(map[string]bool, len(r.Spec.GCP.Delegates))
+	for i, delegate := range r.Spec.GCP.Delegates {
+		if !strings.Contains(delegate, "@") {
+			return field.Invalid(path.Child("delegates").Index(i), delegate, "delegate must be a service account email")
+		}
+		if delegate == r.Spec.TargetServiceAccount {
+			return field.Invalid(path.Child("delegates").Index(i), delegate, "delegate cannot be the targetServiceAccount")
+		}
+		if seen[delegate] {
+			return field.Duplicate(path.Child("delegates").Index(i), delegate)
+		}
+		seen[delegate] = true
+	}
+	return nil
+}
+
+func (r *WorkloadIdentity) validateAWS() error {
+	if r.Spec.AWS == nil {
+		return nil
+	}
+	path := field.NewPath("spec", "aws")
+	profiles := map[string]*AWSProfile{AWS_DEFAULT_PROFILE: nil}
+	for i := range r.Spec.AWS.Profiles {
+		profile := &r.Spec.AWS.Profiles[i]
+		profilePath := path.Child("profiles").Index(i)
+		if !awsProfileNameRegexp.MatchString(profile.Name) {
+			return field.Invalid(profilePath.Child("name"), profile.Name, "name must consist of alphanumeric characters, '_', '.' or '-'")
+		}
+		if _, ok := profiles[profile.Name]; ok {
+			return field.Duplicate(profilePath.Child("name"), profile.Name)
+		}
+		if profile.RoleARN == "" {
+			return field.Required(profilePath.Child("roleARN"), "roleARN cannot be empty")
+		}
+		if err := ValidateSingleLine(profilePath.Child("roleARN"), profile.RoleARN); err != nil {
+			return err
+		}
+		if d := profile.DurationSeconds; d != nil {
+			maxDuration := int32(AWS_MAX_DURATION_SECONDS)
+			if profile.SourceProfile != "" {
+				maxDuration = AWS_MAX_CHAINED_DURATION_SECONDS
+			}
+			if *d < AWS_MIN_DURATION_SECONDS || *d > maxDuration {
+				return field.Invalid(profilePath.Child("durationSeconds"), *d,
+					fmt.Sprintf("durationSeconds must be between %d and %d", AWS_MIN_DURATION_SECONDS, maxDuration))
+			}
+		}
+		profiles[profile.Name] = profile
+	}
+	for i, profile := range r.Spec.AWS.Profiles {
+		if profile.SourceProfile == "" {
+			continue
+		}
+		// Follow the chain to make sure it ends at a profile using the web identity token.
+		visited := map[string]bool{profile.Name: true}
+		for source := profile.SourceProfile; source != ""; {
+			next, ok := profiles[source]
+			if !ok {
+				return field.NotFound(path.Child("profiles").Index(i).Child("sourceProfile"), profile.SourceProfile)
+			}
+			if visited[source] {
+				return field.Invalid(path.Child("profiles").Index(i).Child("sourceProfile"), profile.SourceProfile, "source profiles cannot be cyclic")
+			}
+			visited[source] = true
+			if next == nil {
+				break
+			}
+			source = next.SourceProfile
+		}
+	}
+	if _, ok := profiles[r.Spec.AWS.DefaultProfile]; r.Spec.AWS.DefaultProfile != "" && !ok {
+		return field.NotFound(path.Child("defaultProfile"), r.Spec.AWS.DefaultProfile)
+	}
+	return nil
+}
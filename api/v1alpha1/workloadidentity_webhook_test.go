@@ -18,29 +18,281 @@ package v1alpha1
 
 import (
 	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
 )
 
 var _ = Describe("WorkloadIdentity Webhook", func() {
+	withAWSProfiles := func(wi *WorkloadIdentity, profiles ...AWSProfile) {
+		wi.Spec.TargetServiceAccount = ""
+		wi.Spec.AWS = &WorkloadIdentityAWS{
+			Profiles:       profiles,
+			DefaultProfile: AWS_DEFAULT_PROFILE,
+		}
+	}
+	roleARN := func(name string) string {
+		return "arn:aws:iam::123456789012:role/" + name
+	}
+	newWorkloadIdentity := func() *WorkloadIdentity {
+		return &WorkloadIdentity{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "workloadidentity-1",
+				Namespace: "default",
+			},
+			Spec: WorkloadIdentitySpec{
+				Deployment:           "app",
+				TargetServiceAccount: "app@my-project.iam.gserviceaccount.com",
+				Provider: WorkloadIdentityProvider{
+					Name: "provider-1",
+				},
+			},
+		}
+	}
 
 	Context("When creating WorkloadIdentity under Defaulting Webhook", func() {
 		It("Should fill in the default value if a required field is empty", func() {
-
-			// TODO(user): Add your logic here
-
+			wi := newWorkloadIdentity()
+			wi.Spec.GCP = &WorkloadIdentityGCP{}
+			wi.Default()
+			Expect(wi.Spec.Provider.Namespace).To(Equal("default"))
+			Expect(wi.Spec.GCP.AccessMode).To(Equal(GCPAccessModeImpersonation))
 		})
 	})
 
 	Context("When creating WorkloadIdentity under Validating Webhook", func() {
-		It("Should deny if a required field is empty", func() {
+		DescribeTable("Should deny an invalid spec",
+			func(mutate func(wi *WorkloadIdentity), field string) {
+				wi := newWorkloadIdentity()
+				mutate(wi)
+				_, err := wi.ValidateCreate()
+				Expect(err).To(MatchError(ContainSubstring(field)))
+			},
+			Entry("Empty Deployment", func(wi *WorkloadIdentity) { wi.Spec.Deployment = "" }, "spec.deployment"),
+			Entry("Empty Provider Name", func(wi *WorkloadIdentity) { wi.Spec.Provider.Name = "" }, "spec.provider.name"),
+			Entry("Unknown GCP AccessMode", func(wi *WorkloadIdentity) {
+				wi.Spec.GCP = &WorkloadIdentityGCP{AccessMode: "workload"}
+			}, "spec.gcp.accessMode"),
+			Entry("Impersonation without TargetServiceAccount", func(wi *WorkloadIdentity) {
+				wi.Spec.TargetServiceAccount = ""
+				wi.Spec.GCP = &WorkloadIdentityGCP{AccessMode: GCPAccessModeImpersonation}
+			}, "spec.targetServiceAccount"),
+			Entry("Direct with TargetServiceAccount", func(wi *WorkloadIdentity) {
+				wi.Spec.GCP = &WorkloadIdentityGCP{AccessMode: GCPAccessModeDirect}
+			}, "spec.targetServiceAccount"),
+			Entry("TokenLifetimeSeconds below the minimum", func(wi *WorkloadIdentity) {
+				wi.Spec.GCP = &WorkloadIdentityGCP{TokenLifetimeSeconds: ptr.To[int32](599)}
+			}, "spec.gcp.tokenLifetimeSeconds"),
+			Entry("TokenLifetimeSeconds above the maximum", func(wi *WorkloadIdentity) {
+				wi.Spec.GCP = &WorkloadIdentityGCP{TokenLifetimeSeconds: ptr.To[int32](43201)}
+			}, "spec.gcp.tokenLifetimeSeconds"),
+			Entry("Direct with TokenLifetimeSeconds", func(wi *WorkloadIdentity) {
+				wi.Spec.TargetServiceAccount = ""
+				wi.Spec.GCP = &WorkloadIdentityGCP{
+					AccessMode:           GCPAccessModeDirect,
+					TokenLifetimeSeconds: ptr.To[int32](3600),
+				}
+			}, "spec.gcp.tokenLifetimeSeconds"),
+			Entry("Direct with Delegates", func(wi *WorkloadIdentity) {
+				wi.Spec.TargetServiceAccount = ""
+				wi.Spec.GCP = &WorkloadIdentityGCP{
+					AccessMode: GCPAccessModeDirect,
+					Delegates:  []string{"delegate@my-project.iam.gserviceaccount.com"},
+				}
+			}, "spec.gcp.delegates"),
+			Entry("TokenLifetimeSeconds with Delegates", func(wi *WorkloadIdentity) {
+				wi.Spec.GCP = &WorkloadIdentityGCP{
+					TokenLifetimeSeconds: ptr.To[int32](3600),
+					Delegates:            []string{"delegate@my-project.iam.gserviceaccount.com"},
+				}
+			}, "spec.gcp.tokenLifetimeSeconds"),
+			Entry("Delegate equal to TargetServiceAccount", func(wi *WorkloadIdentity) {
+				wi.Spec.GCP = &WorkloadIdentityGCP{
+					Delegates: []string{wi.Spec.TargetServiceAccount},
+				}
+			}, "spec.gcp.delegates[0]"),
+			Entry("Delegate without an email", func(wi *WorkloadIdentity) {
+				wi.Spec.GCP = &WorkloadIdentityGCP{Delegates: []string{"delegate"}}
+			}, "spec.gcp.delegates[0]"),
+			Entry("Duplicate Delegate", func(wi *WorkloadIdentity) {
+				wi.Spec.GCP = &WorkloadIdentityGCP{
+					Delegates: []string{
+						"delegate@my-project.iam.gserviceaccount.com",
+						"delegate@my-project.iam.gserviceaccount.com",
+					},
+				}
+			}, "spec.gcp.delegates[1]"),
+			Entry("QuotaProjectID with uppercase letters", func(wi *WorkloadIdentity) {
+				wi.Spec.GCP = &WorkloadIdentityGCP{QuotaProjectID: "My-Project"}
+			}, "spec.gcp.quotaProjectID"),
+			Entry("QuotaProjectID starting with a digit", func(wi *WorkloadIdentity) {
+				wi.Spec.GCP = &WorkloadIdentityGCP{QuotaProjectID: "1-project"}
+			}, "spec.gcp.quotaProjectID"),
+			Entry("QuotaProjectID ending with a hyphen", func(wi *WorkloadIdentity) {
+				wi.Spec.GCP = &WorkloadIdentityGCP{QuotaProjectID: "my-project-"}
+			}, "spec.gcp.quotaProjectID"),
+			Entry("Too short QuotaProjectID", func(wi *WorkloadIdentity) {
+				wi.Spec.GCP = &WorkloadIdentityGCP{QuotaProjectID: "proj"}
+			}, "spec.gcp.quotaProjectID"),
+			Entry("Too long QuotaProjectID", func(wi *WorkloadIdentity) {
+				wi.Spec.GCP = &WorkloadIdentityGCP{QuotaProjectID: "my-project-with-a-long-name-123"}
+			}, "spec.gcp.quotaProjectID"),
+			Entry("AWS profile name with a space", func(wi *WorkloadIdentity) {
+				withAWSProfiles(wi, AWSProfile{Name: "read only", RoleARN: roleARN("reader")})
+			}, "spec.aws.profiles[0].name"),
+			Entry("AWS profile name with a bracket", func(wi *WorkloadIdentity) {
+				withAWSProfiles(wi, AWSProfile{Name: "reader]", RoleARN: roleARN("reader")})
+			}, "spec.aws.profiles[0].name"),
+			Entry("AWS profile named default", func(wi *WorkloadIdentity) {
+				withAWSProfiles(wi, AWSProfile{Name: AWS_DEFAULT_PROFILE, RoleARN: roleARN("reader")})
+			}, "spec.aws.profiles[0].name"),
+			Entry("Duplicate AWS profile", func(wi *WorkloadIdentity) {
+				withAWSProfiles(wi,
+					AWSProfile{Name: "reader", RoleARN: roleARN("reader")},
+					AWSProfile{Name: "reader", RoleARN: roleARN("writer")},
+				)
+			}, "spec.aws.profiles[1].name"),
+			Entry("Empty AWS profile RoleARN", func(wi *WorkloadIdentity) {
+				withAWSProfiles(wi, AWSProfile{Name: "reader"})
+			}, "spec.aws.profiles[0].roleARN"),
+			Entry("Multi-line AWS profile RoleARN", func(wi *WorkloadIdentity) {
+				withAWSProfiles(wi, AWSProfile{Name: "reader", RoleARN: roleARN("reader") + "\n[profile admin]"})
+			}, "spec.aws.profiles[0].roleARN"),
+			Entry("AWS DurationSeconds below the minimum", func(wi *WorkloadIdentity) {
+				withAWSProfiles(wi, AWSProfile{Name: "reader", RoleARN: roleARN("reader"), DurationSeconds: ptr.To[int32](899)})
+			}, "spec.aws.profiles[0].durationSeconds"),
+			Entry("AWS DurationSeconds above the maximum", func(wi *WorkloadIdentity) {
+				withAWSProfiles(wi, AWSProfile{Name: "reader", RoleARN: roleARN("reader"), DurationSeconds: ptr.To[int32](43201)})
+			}, "spec.aws.profiles[0].durationSeconds"),
+			Entry("Chained AWS DurationSeconds above an hour", func(wi *WorkloadIdentity) {
+				withAWSProfiles(wi, AWSProfile{
+					Name:            "reader",
+					RoleARN:         roleARN("reader"),
+					SourceProfile:   AWS_DEFAULT_PROFILE,
+					DurationSeconds: ptr.To[int32](3601),
+				})
+			}, "spec.aws.profiles[0].durationSeconds"),
+			Entry("Unknown AWS SourceProfile", func(wi *WorkloadIdentity) {
+				withAWSProfiles(wi, AWSProfile{Name: "reader", RoleARN: roleARN("reader"), SourceProfile: "admin"})
+			}, "spec.aws.profiles[0].sourceProfile"),
+			Entry("AWS SourceProfile of itself", func(wi *WorkloadIdentity) {
+				withAWSProfiles(wi, AWSProfile{Name: "reader", RoleARN: roleARN("reader"), SourceProfile: "reader"})
+			}, "spec.aws.profiles[0].sourceProfile"),
+			Entry("Cyclic AWS SourceProfiles", func(wi *WorkloadIdentity) {
+				withAWSProfiles(wi,
+					AWSProfile{Name: "reader", RoleARN: roleARN("reader"), SourceProfile: "writer"},
+					AWSProfile{Name: "writer", RoleARN: roleARN("writer"), SourceProfile: "reader"},
+				)
+			}, "spec.aws.profiles[0].sourceProfile"),
+			Entry("AWS SourceProfile leading into a cycle", func(wi *WorkloadIdentity) {
+				withAWSProfiles(wi,
+					AWSProfile{Name: "reader", RoleARN: roleARN("reader"), SourceProfile: "writer"},
+					AWSProfile{Name: "writer", RoleARN: roleARN("writer"), SourceProfile: "admin"},
+					AWSProfile{Name: "admin", RoleARN: roleARN("admin"), SourceProfile: "writer"},
+				)
+			}, "spec.aws.profiles[0].sourceProfile"),
+			Entry("Unknown AWS DefaultProfile", func(wi *WorkloadIdentity) {
+				withAWSProfiles(wi, AWSProfile{Name: "reader", RoleARN: roleARN("reader")})
+				wi.Spec.AWS.DefaultProfile = "writer"
+			}, "spec.aws.defaultProfile"),
+		)
+
+		DescribeTable("Should admit a valid spec",
+			func(mutate func(wi *WorkloadIdentity)) {
+				wi := newWorkloadIdentity()
+				mutate(wi)
+				warns, err := wi.ValidateCreate()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(warns).To(BeNil())
+			},
+			Entry("Required fields only", func(wi *WorkloadIdentity) {}),
+			Entry("Default GCP AccessMode", func(wi *WorkloadIdentity) {
+				wi.Spec.GCP = &WorkloadIdentityGCP{}
+			}),
+			Entry("Direct without TargetServiceAccount", func(wi *WorkloadIdentity) {
+				wi.Spec.TargetServiceAccount = ""
+				wi.Spec.GCP = &WorkloadIdentityGCP{AccessMode: GCPAccessModeDirect}
+			}),
+			Entry("TokenLifetimeSeconds at the minimum", func(wi *WorkloadIdentity) {
+				wi.Spec.GCP = &WorkloadIdentityGCP{TokenLifetimeSeconds: ptr.To[int32](600)}
+			}),
+			Entry("TokenLifetimeSeconds at the maximum", func(wi *WorkloadIdentity) {
+				wi.Spec.GCP = &WorkloadIdentityGCP{TokenLifetimeSeconds: ptr.To[int32](43200)}
+			}),
+			Entry("Chain of Delegates", func(wi *WorkloadIdentity) {
+				wi.Spec.GCP = &WorkloadIdentityGCP{
+					Delegates: []string{
+						"delegate-1@my-project.iam.gserviceaccount.com",
+						"delegate-2@my-project.iam.gserviceaccount.com",
+					},
+				}
+			}),
+			Entry("QuotaProjectID", func(wi *WorkloadIdentity) {
+				wi.Spec.GCP = &WorkloadIdentityGCP{QuotaProjectID: "my-billing-project"}
+			}),
+			Entry("QuotaProjectID of the maximum length", func(wi *WorkloadIdentity) {
+				wi.Spec.GCP = &WorkloadIdentityGCP{QuotaProjectID: "my-project-with-a-long-name-12"}
+			}),
+			Entry("AWS DurationSeconds at the bounds", func(wi *WorkloadIdentity) {
+				withAWSProfiles(wi,
+					AWSProfile{Name: "reader", RoleARN: roleARN("reader"), DurationSeconds: ptr.To[int32](900)},
+					AWSProfile{Name: "writer", RoleARN: roleARN("writer"), DurationSeconds: ptr.To[int32](43200)},
+					AWSProfile{
+						Name:            "admin",
+						RoleARN:         roleARN("admin"),
+						SourceProfile:   "writer",
+						DurationSeconds: ptr.To[int32](3600),
+					},
+				)
+			}),
+			Entry("AWS SourceProfiles chained to the web identity", func(wi *WorkloadIdentity) {
+				withAWSProfiles(wi,
+					AWSProfile{Name: "admin", RoleARN: roleARN("admin"), SourceProfile: "writer"},
+					AWSProfile{Name: "writer", RoleARN: roleARN("writer"), SourceProfile: "reader"},
+					AWSProfile{Name: "reader", RoleARN: roleARN("reader"), SourceProfile: AWS_DEFAULT_PROFILE},
+				)
+				wi.Spec.AWS.DefaultProfile = "admin"
+			}),
+		)
+	})
 
-			// TODO(user): Add your logic here
+	Context("When updating WorkloadIdentity under Validating Webhook", func() {
+		retarget := func(rejected bool) (*WorkloadIdentity, *WorkloadIdentity) {
+			previous := RejectDeploymentRetargeting
+			RejectDeploymentRetargeting = rejected
+			DeferCleanup(func() {
+				RejectDeploymentRetargeting = previous
+			})
+			old := newWorkloadIdentity()
+			wi := newWorkloadIdentity()
+			wi.Spec.Deployment = "other-app"
+			return old, wi
+		}
 
+		It("Should warn about retargeting the deployment", func() {
+			old, wi := retarget(false)
+			warns, err := wi.ValidateUpdate(old)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warns).To(ConsistOf(And(
+				ContainSubstring("spec.deployment"),
+				ContainSubstring(`"app"`),
+				ContainSubstring(`"other-app"`),
+			)))
 		})
 
-		It("Should admit if all required fields are provided", func() {
-
-			// TODO(user): Add your logic here
+		It("Should deny retargeting the deployment when it is rejected", func() {
+			old, wi := retarget(true)
+			_, err := wi.ValidateUpdate(old)
+			Expect(err).To(MatchError(ContainSubstring("spec.deployment")))
+		})
 
+		It("Should admit an update keeping the deployment without warnings", func() {
+			old, wi := retarget(true)
+			wi.Spec.Deployment = old.Spec.Deployment
+			wi.Spec.DisableAutoRollout = true
+			warns, err := wi.ValidateUpdate(old)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warns).To(BeEmpty())
 		})
 	})
 
@@ -58,6 +58,7 @@ func main() {
 	var probeAddr string
 	var secureMetrics bool
 	var enableHTTP2 bool
+	var rejectDeploymentRetargeting bool
 	var tlsOpts []func(*tls.Config)
 	flag.StringVar(&metricsAddr, "metrics-bind-address", "0", "The address the metrics endpoint binds to. "+
 		"Use :8443 for HTTPS or :8080 for HTTP, or leave as 0 to disable the metrics service.")
@@ -69,6 +70,8 @@ func main() {
 		"If set, the metrics endpoint is served securely via HTTPS. Use --metrics-secure=false to use HTTP instead.")
 	flag.BoolVar(&enableHTTP2, "enable-http2", false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
+	flag.BoolVar(&rejectDeploymentRetargeting, "reject-deployment-retargeting", false,
+		"If set, the webhook rejects changes of spec.deployment on WorkloadIdentities instead of only warning about them.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -165,6 +168,7 @@ func main() {
 		os.Exit(1)
 	}
 	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		k8sv1alpha1.RejectDeploymentRetargeting = rejectDeploymentRetargeting
 		if err = (&k8sv1alpha1.WorkloadIdentity{}).SetupWebhookWithManager(mgr); err != nil {
 			setupLog.Error(err, "unable to create webhook", "webhook", "WorkloadIdentity")
 			os.Exit(1)
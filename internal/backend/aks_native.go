@@ -0,0 +1,83 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	k8sv1alpha1 "github.com/piny940/kwimount/api/v1alpha1"
+)
+
+const (
+	AKS_CLIENT_ID_ANNOTATION = "azure.workload.identity/client-id"
+	AKS_TENANT_ID_ANNOTATION = "azure.workload.identity/tenant-id"
+	AKS_USE_LABEL            = "azure.workload.identity/use"
+)
+
+// aksNative delegates to the Microsoft Entra Workload ID webhook of AKS, which
+// mounts the token and injects the environment into pods labeled with
+// azure.workload.identity/use.
+type aksNative struct{}
+
+var _ Backend = &aksNative{}
+
+func init() {
+	Register(k8sv1alpha1.ProviderTargetTypeAKSNative, &aksNative{})
+}
+
+func (a *aksNative) Validate(spec *k8sv1alpha1.ProviderSpec) error {
+	if spec.Azure != nil && spec.Azure.TenantID == "" {
+		return field.Invalid(field.NewPath("spec", "azure", "tenantID"), spec.Azure.TenantID, "tenantID cannot be empty")
+	}
+	return nil
+}
+
+func (a *aksNative) ValidateWorkloadIdentity(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider) error {
+	if wi.Spec.Azure == nil {
+		return fmt.Errorf("azure is required on WorkloadIdentity for provider target %s", pr.Spec.Target)
+	}
+	return nil
+}
+
+func (a *aksNative) Audience(pr *k8sv1alpha1.Provider) string {
+	return ""
+}
+
+func (a *aksNative) ConfigData(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider) (map[string]string, error) {
+	return map[string]string{}, nil
+}
+
+func (a *aksNative) Workload(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider, configMapName string) *Workload {
+	annotations := map[string]string{
+		AKS_CLIENT_ID_ANNOTATION: wi.Spec.Azure.ClientID,
+	}
+	if pr.Spec.Azure != nil {
+		annotations[AKS_TENANT_ID_ANNOTATION] = pr.Spec.Azure.TenantID
+	}
+	return &Workload{
+		ServiceAccountAnnotations: annotations,
+		// Older versions of the webhook look for the label on the service account.
+		ServiceAccountLabels: map[string]string{
+			AKS_USE_LABEL: "true",
+		},
+		PodLabels: map[string]string{
+			AKS_USE_LABEL: "true",
+		},
+	}
+}
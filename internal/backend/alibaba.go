@@ -0,0 +1,92 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+
+	k8sv1alpha1 "github.com/piny940/kwimount/api/v1alpha1"
+)
+
+const (
+	ALIBABA_TOKEN_MOUNT_PATH  = "/var/run/kwimount-alibaba-service-account/"
+	ALIBABA_TOKEN_PATH        = "token"
+	ALIBABA_TOKEN_VOLUME_NAME = "kwimount-alibaba-token"
+	ALIBABA_TOKEN_AUDIENCE    = "sts.aliyuncs.com"
+	ALIBABA_ARN_PREFIX        = "acs:ram::"
+
+	ALIBABA_CLOUD_ROLE_ARN_ENV          = "ALIBABA_CLOUD_ROLE_ARN"
+	ALIBABA_CLOUD_OIDC_PROVIDER_ARN_ENV = "ALIBABA_CLOUD_OIDC_PROVIDER_ARN"
+	ALIBABA_CLOUD_OIDC_TOKEN_FILE_ENV   = "ALIBABA_CLOUD_OIDC_TOKEN_FILE"
+)
+
+type alibaba struct{}
+
+var _ Backend = &alibaba{}
+
+func init() {
+	Register(k8sv1alpha1.ProviderTargetTypeAlibaba, &alibaba{})
+}
+
+func (a *alibaba) Validate(spec *k8sv1alpha1.ProviderSpec) error {
+	path := field.NewPath("spec", "alibaba")
+	if spec.Alibaba == nil {
+		return field.Required(path, "alibaba cannot be empty when target is alibaba")
+	}
+	if !strings.HasPrefix(spec.Alibaba.RoleARN, ALIBABA_ARN_PREFIX) {
+		return field.Invalid(path.Child("roleARN"), spec.Alibaba.RoleARN, fmt.Sprintf("roleARN must start with %s", ALIBABA_ARN_PREFIX))
+	}
+	if !strings.HasPrefix(spec.Alibaba.OIDCProviderARN, ALIBABA_ARN_PREFIX) {
+		return field.Invalid(path.Child("oidcProviderARN"), spec.Alibaba.OIDCProviderARN, fmt.Sprintf("oidcProviderARN must start with %s", ALIBABA_ARN_PREFIX))
+	}
+	return nil
+}
+
+func (a *alibaba) ValidateWorkloadIdentity(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider) error {
+	if pr.Spec.Alibaba == nil {
+		return fmt.Errorf("alibaba is required for provider target %s", pr.Spec.Target)
+	}
+	return nil
+}
+
+func (a *alibaba) Audience(pr *k8sv1alpha1.Provider) string {
+	return ALIBABA_TOKEN_AUDIENCE
+}
+
+// ConfigData returns no files because the Alibaba Cloud credentials SDKs are
+// configured through environment variables only.
+func (a *alibaba) ConfigData(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider) (map[string]string, error) {
+	return map[string]string{}, nil
+}
+
+func (a *alibaba) Workload(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider, configMapName string) *Workload {
+	return &Workload{
+		Env: []*corev1apply.EnvVarApplyConfiguration{
+			Env(ALIBABA_CLOUD_ROLE_ARN_ENV, pr.Spec.Alibaba.RoleARN),
+			Env(ALIBABA_CLOUD_OIDC_PROVIDER_ARN_ENV, pr.Spec.Alibaba.OIDCProviderARN),
+			Env(ALIBABA_CLOUD_OIDC_TOKEN_FILE_ENV, ALIBABA_TOKEN_MOUNT_PATH+ALIBABA_TOKEN_PATH),
+		},
+		VolumeMounts: []*corev1apply.VolumeMountApplyConfiguration{
+			ReadOnlyMount(ALIBABA_TOKEN_VOLUME_NAME, ALIBABA_TOKEN_MOUNT_PATH),
+		},
+		Token: SingleToken(ALIBABA_TOKEN_VOLUME_NAME, a.Audience(pr), ALIBABA_TOKEN_PATH),
+	}
+}
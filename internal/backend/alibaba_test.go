@@ -0,0 +1,76 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	k8sv1alpha1 "github.com/piny940/kwimount/api/v1alpha1"
+)
+
+var _ = Describe("Alibaba Backend", func() {
+	newProvider := func() *k8sv1alpha1.Provider {
+		return &k8sv1alpha1.Provider{
+			Spec: k8sv1alpha1.ProviderSpec{
+				Target: k8sv1alpha1.ProviderTargetTypeAlibaba,
+				Alibaba: &k8sv1alpha1.AlibabaProvider{
+					RoleARN:         "acs:ram::123456789012:role/app",
+					OIDCProviderARN: "acs:ram::123456789012:oidc-provider/cluster",
+				},
+			},
+		}
+	}
+
+	It("Should inject the RRSA environment and token", func() {
+		pr := newProvider()
+		b, err := Get(pr.Spec.Target)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(b.Validate(&pr.Spec)).To(Succeed())
+
+		data, w, err := Render(b, &k8sv1alpha1.WorkloadIdentity{}, pr, "conf")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data).To(BeEmpty())
+		Expect(envMap(w)).To(Equal(map[string]string{
+			ALIBABA_CLOUD_ROLE_ARN_ENV:          "acs:ram::123456789012:role/app",
+			ALIBABA_CLOUD_OIDC_PROVIDER_ARN_ENV: "acs:ram::123456789012:oidc-provider/cluster",
+			ALIBABA_CLOUD_OIDC_TOKEN_FILE_ENV:   ALIBABA_TOKEN_MOUNT_PATH + ALIBABA_TOKEN_PATH,
+		}))
+
+		Expect(w.VolumeMounts).To(HaveLen(1))
+		Expect(*w.VolumeMounts[0].Name).To(Equal(ALIBABA_TOKEN_VOLUME_NAME))
+		Expect(*w.VolumeMounts[0].MountPath).To(Equal(ALIBABA_TOKEN_MOUNT_PATH))
+		Expect(*w.VolumeMounts[0].ReadOnly).To(BeTrue())
+
+		token := findVolume(w, ALIBABA_TOKEN_VOLUME_NAME)
+		Expect(token).NotTo(BeNil())
+		Expect(*token.Projected.Sources[0].ServiceAccountToken.Audience).To(Equal("sts.aliyuncs.com"))
+		Expect(*token.Projected.Sources[0].ServiceAccountToken.Path).To(Equal(ALIBABA_TOKEN_PATH))
+	})
+
+	It("Should reject ARNs outside of RAM", func() {
+		pr := newProvider()
+		pr.Spec.Alibaba.RoleARN = "arn:aws:iam::123456789012:role/app"
+		b, err := Get(pr.Spec.Target)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(b.Validate(&pr.Spec)).NotTo(Succeed())
+
+		pr = newProvider()
+		pr.Spec.Alibaba.OIDCProviderARN = "cluster"
+		Expect(b.Validate(&pr.Spec)).NotTo(Succeed())
+	})
+})
@@ -0,0 +1,181 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+
+	k8sv1alpha1 "github.com/piny940/kwimount/api/v1alpha1"
+)
+
+const (
+	AWS_TOKEN_MOUNT_PATH  = "/var/run/kwimount-aws-service-account/"
+	AWS_TOKEN_PATH        = "token"
+	AWS_TOKEN_VOLUME_NAME = "kwimount-aws-token"
+	AWS_TOKEN_AUDIENCE    = "sts.amazonaws.com"
+
+	AWS_ROLE_ARN_ENV                = "AWS_ROLE_ARN"
+	AWS_WEB_IDENTITY_TOKEN_FILE_ENV = "AWS_WEB_IDENTITY_TOKEN_FILE"
+	AWS_REGION_ENV                  = "AWS_REGION"
+	AWS_STS_REGIONAL_ENDPOINTS_ENV  = "AWS_STS_REGIONAL_ENDPOINTS"
+	AWS_ROLE_SESSION_NAME_ENV       = "AWS_ROLE_SESSION_NAME"
+	AWS_PROFILE_ENV                 = "AWS_PROFILE"
+	AWS_CONFIG_FILE_ENV             = "AWS_CONFIG_FILE"
+	AWS_SDK_LOAD_CONFIG_ENV         = "AWS_SDK_LOAD_CONFIG"
+
+	AWS_CONFIGURATION_MOUNT_PATH = "/etc/kwimount-aws/"
+	AWS_CONFIGURATION_FILE_NAME  = "aws-config"
+)
+
+type aws struct{}
+
+var _ Backend = &aws{}
+
+func init() {
+	Register(k8sv1alpha1.ProviderTargetTypeAWS, &aws{})
+}
+
+func (a *aws) Validate(spec *k8sv1alpha1.ProviderSpec) error {
+	path := field.NewPath("spec", "aws")
+	if spec.AWS == nil {
+		return field.Required(path, "aws cannot be empty when target is aws")
+	}
+	if spec.AWS.RoleARN == "" {
+		return field.Invalid(path.Child("roleARN"), spec.AWS.RoleARN, "roleARN cannot be empty")
+	}
+	if spec.AWS.Region == "" {
+		return field.Invalid(path.Child("region"), spec.AWS.Region, "region cannot be empty")
+	}
+	if err := k8sv1alpha1.ValidateSingleLine(path.Child("roleARN"), spec.AWS.RoleARN); err != nil {
+		return err
+	}
+	if err := k8sv1alpha1.ValidateSingleLine(path.Child("region"), spec.AWS.Region); err != nil {
+		return err
+	}
+	if err := k8sv1alpha1.ValidateSingleLine(path.Child("sessionName"), spec.AWS.SessionName); err != nil {
+		return err
+	}
+	if spec.AWS.STSRegionalEndpoints != "" && !slices.Contains(k8sv1alpha1.AllAWSSTSRegionalEndpoints, spec.AWS.STSRegionalEndpoints) {
+		return field.Invalid(path.Child("stsRegionalEndpoints"), spec.AWS.STSRegionalEndpoints, fmt.Sprintf("stsRegionalEndpoints must be one of %v", k8sv1alpha1.AllAWSSTSRegionalEndpoints))
+	}
+	return nil
+}
+
+func (a *aws) ValidateWorkloadIdentity(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider) error {
+	if pr.Spec.AWS == nil {
+		return fmt.Errorf("aws is required for provider target %s", pr.Spec.Target)
+	}
+	return nil
+}
+
+func (a *aws) Audience(pr *k8sv1alpha1.Provider) string {
+	return AWS_TOKEN_AUDIENCE
+}
+
+// ConfigData returns no files unless the WorkloadIdentity has profiles, in
+// which case the AWS SDKs are configured through a shared config file.
+func (a *aws) ConfigData(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider) (map[string]string, error) {
+	if !awsProfilesEnabled(wi) {
+		return map[string]string{}, nil
+	}
+	return map[string]string{
+		AWS_CONFIGURATION_FILE_NAME: awsSharedConfig(wi, pr),
+	}, nil
+}
+
+// awsSharedConfig renders a profile assuming the role of the Provider as the
+// default profile, followed by the profiles of the WorkloadIdentity. The
+// values are validated by the webhooks not to contain line breaks.
+func awsSharedConfig(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider) string {
+	var conf strings.Builder
+	writeProfile := func(header string, profile k8sv1alpha1.AWSProfile) {
+		conf.WriteString("[" + header + "]\n")
+		conf.WriteString("role_arn = " + profile.RoleARN + "\n")
+		if profile.SourceProfile != "" {
+			conf.WriteString("source_profile = " + profile.SourceProfile + "\n")
+		} else {
+			conf.WriteString("web_identity_token_file = " + AWS_TOKEN_MOUNT_PATH + AWS_TOKEN_PATH + "\n")
+		}
+		if profile.DurationSeconds != nil {
+			conf.WriteString(fmt.Sprintf("duration_seconds = %d\n", *profile.DurationSeconds))
+		}
+		if pr.Spec.AWS.SessionName != "" {
+			conf.WriteString("role_session_name = " + pr.Spec.AWS.SessionName + "\n")
+		}
+		conf.WriteString("region = " + pr.Spec.AWS.Region + "\n")
+		conf.WriteString("sts_regional_endpoints = " + string(pr.Spec.AWS.STSRegionalEndpoints) + "\n")
+	}
+	writeProfile(k8sv1alpha1.AWS_DEFAULT_PROFILE, k8sv1alpha1.AWSProfile{RoleARN: pr.Spec.AWS.RoleARN})
+	for _, profile := range wi.Spec.AWS.Profiles {
+		conf.WriteString("\n")
+		writeProfile("profile "+profile.Name, profile)
+	}
+	return conf.String()
+}
+
+func awsProfilesEnabled(wi *k8sv1alpha1.WorkloadIdentity) bool {
+	return wi.Spec.AWS != nil && len(wi.Spec.AWS.Profiles) > 0
+}
+
+func (a *aws) Workload(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider, configMapName string) *Workload {
+	if awsProfilesEnabled(wi) {
+		defaultProfile := wi.Spec.AWS.DefaultProfile
+		if defaultProfile == "" {
+			defaultProfile = k8sv1alpha1.AWS_DEFAULT_PROFILE
+		}
+		// AWS_ROLE_ARN and AWS_WEB_IDENTITY_TOKEN_FILE are left out since some
+		// SDKs prefer them to the profile.
+		return &Workload{
+			Env: []*corev1apply.EnvVarApplyConfiguration{
+				Env(AWS_CONFIG_FILE_ENV, AWS_CONFIGURATION_MOUNT_PATH+AWS_CONFIGURATION_FILE_NAME),
+				Env(AWS_SDK_LOAD_CONFIG_ENV, "1"),
+				Env(AWS_PROFILE_ENV, defaultProfile),
+				Env(AWS_REGION_ENV, pr.Spec.AWS.Region),
+				Env(AWS_STS_REGIONAL_ENDPOINTS_ENV, string(pr.Spec.AWS.STSRegionalEndpoints)),
+			},
+			VolumeMounts: []*corev1apply.VolumeMountApplyConfiguration{
+				ReadOnlyMount(AWS_TOKEN_VOLUME_NAME, AWS_TOKEN_MOUNT_PATH),
+				ReadOnlyMount(configMapName, AWS_CONFIGURATION_MOUNT_PATH),
+			},
+			Volumes: []*corev1apply.VolumeApplyConfiguration{
+				ConfigMapVolume(configMapName),
+			},
+			Token: SingleToken(AWS_TOKEN_VOLUME_NAME, a.Audience(pr), AWS_TOKEN_PATH),
+		}
+	}
+	env := []*corev1apply.EnvVarApplyConfiguration{
+		Env(AWS_ROLE_ARN_ENV, pr.Spec.AWS.RoleARN),
+		Env(AWS_WEB_IDENTITY_TOKEN_FILE_ENV, AWS_TOKEN_MOUNT_PATH+AWS_TOKEN_PATH),
+		Env(AWS_REGION_ENV, pr.Spec.AWS.Region),
+		Env(AWS_STS_REGIONAL_ENDPOINTS_ENV, string(pr.Spec.AWS.STSRegionalEndpoints)),
+	}
+	if pr.Spec.AWS.SessionName != "" {
+		env = append(env, Env(AWS_ROLE_SESSION_NAME_ENV, pr.Spec.AWS.SessionName))
+	}
+	return &Workload{
+		Env: env,
+		VolumeMounts: []*corev1apply.VolumeMountApplyConfiguration{
+			ReadOnlyMount(AWS_TOKEN_VOLUME_NAME, AWS_TOKEN_MOUNT_PATH),
+		},
+		Token: SingleToken(AWS_TOKEN_VOLUME_NAME, a.Audience(pr), AWS_TOKEN_PATH),
+	}
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+
+	k8sv1alpha1 "github.com/piny940/kwimount/api/v1alpha1"
+)
+
+var _ = Describe("AWS Backend", func() {
+	pr := &k8sv1alpha1.Provider{
+		Spec: k8sv1alpha1.ProviderSpec{
+			Target: k8sv1alpha1.ProviderTargetTypeAWS,
+			AWS: &k8sv1alpha1.AWSProvider{
+				RoleARN:              "arn:aws:iam::123456789012:role/base",
+				Region:               "ap-northeast-1",
+				STSRegionalEndpoints: k8sv1alpha1.AWSSTSRegionalEndpointsRegional,
+				SessionName:          "app",
+			},
+		},
+	}
+
+	It("Should render a shared config with a profile per role", func() {
+		wi := &k8sv1alpha1.WorkloadIdentity{
+			ObjectMeta: metav1.ObjectMeta{Name: "wi", Namespace: "default"},
+			Spec: k8sv1alpha1.WorkloadIdentitySpec{
+				Deployment: "app",
+				AWS: &k8sv1alpha1.WorkloadIdentityAWS{
+					DefaultProfile: "reader",
+					Profiles: []k8sv1alpha1.AWSProfile{
+						{Name: "reader", RoleARN: "arn:aws:iam::123456789012:role/reader", DurationSeconds: ptr.To[int32](7200)},
+						{Name: "cross-account", RoleARN: "arn:aws:iam::210987654321:role/writer", SourceProfile: "reader"},
+					},
+				},
+			},
+		}
+		b, err := Get(pr.Spec.Target)
+		Expect(err).NotTo(HaveOccurred())
+		data, w, err := Render(b, wi, pr, "conf")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data[AWS_CONFIGURATION_FILE_NAME]).To(Equal(`[default]
+role_arn = arn:aws:iam::123456789012:role/base
+web_identity_token_file = /var/run/kwimount-aws-service-account/token
+role_session_name = app
+region = ap-northeast-1
+sts_regional_endpoints = regional
+
+[profile reader]
+role_arn = arn:aws:iam::123456789012:role/reader
+web_identity_token_file = /var/run/kwimount-aws-service-account/token
+duration_seconds = 7200
+role_session_name = app
+region = ap-northeast-1
+sts_regional_endpoints = regional
+
+[profile cross-account]
+role_arn = arn:aws:iam::210987654321:role/writer
+source_profile = reader
+role_session_name = app
+region = ap-northeast-1
+sts_regional_endpoints = regional
+`))
+		Expect(envMap(w)).To(Equal(map[string]string{
+			AWS_CONFIG_FILE_ENV:            AWS_CONFIGURATION_MOUNT_PATH + AWS_CONFIGURATION_FILE_NAME,
+			AWS_SDK_LOAD_CONFIG_ENV:        "1",
+			AWS_PROFILE_ENV:                "reader",
+			AWS_REGION_ENV:                 "ap-northeast-1",
+			AWS_STS_REGIONAL_ENDPOINTS_ENV: "regional",
+		}))
+		Expect(findVolume(w, "conf")).NotTo(BeNil())
+		Expect(findVolume(w, AWS_TOKEN_VOLUME_NAME).Projected).NotTo(BeNil())
+	})
+
+	It("Should keep the environment configuration without profiles", func() {
+		wi := &k8sv1alpha1.WorkloadIdentity{
+			ObjectMeta: metav1.ObjectMeta{Name: "wi", Namespace: "default"},
+			Spec:       k8sv1alpha1.WorkloadIdentitySpec{Deployment: "app"},
+		}
+		b, err := Get(pr.Spec.Target)
+		Expect(err).NotTo(HaveOccurred())
+		data, w, err := Render(b, wi, pr, "conf")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data).To(BeEmpty())
+		Expect(envMap(w)).To(HaveKeyWithValue(AWS_ROLE_ARN_ENV, "arn:aws:iam::123456789012:role/base"))
+		Expect(envMap(w)).NotTo(HaveKey(AWS_CONFIG_FILE_ENV))
+	})
+})
@@ -0,0 +1,93 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"fmt"
+	"slices"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+
+	k8sv1alpha1 "github.com/piny940/kwimount/api/v1alpha1"
+)
+
+const (
+	AZURE_TOKEN_MOUNT_PATH  = "/var/run/kwimount-azure-service-account/"
+	AZURE_TOKEN_PATH        = "token"
+	AZURE_TOKEN_VOLUME_NAME = "kwimount-azure-token"
+	AZURE_TOKEN_AUDIENCE    = "api://AzureADTokenExchange"
+
+	AZURE_CLIENT_ID_ENV            = "AZURE_CLIENT_ID"
+	AZURE_TENANT_ID_ENV            = "AZURE_TENANT_ID"
+	AZURE_FEDERATED_TOKEN_FILE_ENV = "AZURE_FEDERATED_TOKEN_FILE"
+	AZURE_AUTHORITY_HOST_ENV       = "AZURE_AUTHORITY_HOST"
+)
+
+type azure struct{}
+
+var _ Backend = &azure{}
+
+func init() {
+	Register(k8sv1alpha1.ProviderTargetTypeAzure, &azure{})
+}
+
+func (a *azure) Validate(spec *k8sv1alpha1.ProviderSpec) error {
+	path := field.NewPath("spec", "azure")
+	if spec.Azure == nil {
+		return field.Required(path, "azure cannot be empty when target is azure")
+	}
+	if spec.Azure.TenantID == "" {
+		return field.Invalid(path.Child("tenantID"), spec.Azure.TenantID, "tenantID cannot be empty")
+	}
+	if spec.Azure.Cloud != "" && !slices.Contains(k8sv1alpha1.AllAzureClouds, spec.Azure.Cloud) {
+		return field.Invalid(path.Child("cloud"), spec.Azure.Cloud, fmt.Sprintf("cloud must be one of %v", k8sv1alpha1.AllAzureClouds))
+	}
+	return nil
+}
+
+func (a *azure) ValidateWorkloadIdentity(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider) error {
+	if pr.Spec.Azure == nil || wi.Spec.Azure == nil {
+		return fmt.Errorf("azure is required on both Provider and WorkloadIdentity for provider target %s", pr.Spec.Target)
+	}
+	return nil
+}
+
+func (a *azure) Audience(pr *k8sv1alpha1.Provider) string {
+	return AZURE_TOKEN_AUDIENCE
+}
+
+// ConfigData returns no files because the Azure Identity SDKs are configured
+// through environment variables only.
+func (a *azure) ConfigData(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider) (map[string]string, error) {
+	return map[string]string{}, nil
+}
+
+func (a *azure) Workload(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider, configMapName string) *Workload {
+	return &Workload{
+		Env: []*corev1apply.EnvVarApplyConfiguration{
+			Env(AZURE_CLIENT_ID_ENV, wi.Spec.Azure.ClientID),
+			Env(AZURE_TENANT_ID_ENV, pr.Spec.Azure.TenantID),
+			Env(AZURE_FEDERATED_TOKEN_FILE_ENV, AZURE_TOKEN_MOUNT_PATH+AZURE_TOKEN_PATH),
+			Env(AZURE_AUTHORITY_HOST_ENV, pr.Spec.Azure.Cloud.AuthorityHost()),
+		},
+		VolumeMounts: []*corev1apply.VolumeMountApplyConfiguration{
+			ReadOnlyMount(AZURE_TOKEN_VOLUME_NAME, AZURE_TOKEN_MOUNT_PATH),
+		},
+		Token: SingleToken(AZURE_TOKEN_VOLUME_NAME, a.Audience(pr), AZURE_TOKEN_PATH),
+	}
+}
@@ -0,0 +1,225 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package backend contains the provider targets supported by kwimount. Each
+// backend knows how to render the credentials of its target into the managed
+// ConfigMap and the target Deployment.
+package backend
+
+import (
+	"errors"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+
+	k8sv1alpha1 "github.com/piny940/kwimount/api/v1alpha1"
+)
+
+const (
+	TOKEN_EXPIRATION_SEC = 3600
+)
+
+// Backend renders the credentials of a provider target.
+type Backend interface {
+	// Validate validates the target specific fields of a Provider. It is run by the Provider webhook.
+	Validate(spec *k8sv1alpha1.ProviderSpec) error
+	// ValidateWorkloadIdentity validates the target specific fields of a WorkloadIdentity bound to the Provider.
+	ValidateWorkloadIdentity(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider) error
+	// Audience returns the audience of the projected service account token.
+	Audience(pr *k8sv1alpha1.Provider) string
+	// ConfigData returns the files stored in the managed ConfigMap.
+	ConfigData(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider) (map[string]string, error)
+	// Workload returns what is injected into the target Deployment.
+	Workload(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider, configMapName string) *Workload
+}
+
+// PrincipalReporter is implemented by backends whose federated identity may be
+// granted permissions directly, so that it can be reported in the status.
+type PrincipalReporter interface {
+	// Principal returns the identity of pods running as the Kubernetes service account, or
+	// an empty string if the identity is not used directly.
+	Principal(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider, serviceAccountName string) string
+}
+
+// ClaimChecker is implemented by backends that can tell in advance whether the
+// tokens of the pods would be accepted by the identity provider.
+type ClaimChecker interface {
+	// CheckClaims returns an error describing why the tokens of pods running as the
+	// Kubernetes service account would be rejected. The error wraps ErrClaimsUnknown
+	// when the result depends on claims the controller cannot know.
+	CheckClaims(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider, serviceAccountName string) error
+}
+
+// ErrClaimsUnknown is returned by ClaimChecker when the claims the identity
+// provider checks are only known once the pods are running.
+var ErrClaimsUnknown = errors.New("claims are not known in advance")
+
+// Workload is injected into the target Deployment. Env and VolumeMounts are
+// added to every container, while Containers are added as sidecars.
+type Workload struct {
+	Env          []*corev1apply.EnvVarApplyConfiguration
+	VolumeMounts []*corev1apply.VolumeMountApplyConfiguration
+	Volumes      []*corev1apply.VolumeApplyConfiguration
+	Containers   []*corev1apply.ContainerApplyConfiguration
+	// Token is the volume holding the subject tokens. Render turns it into a
+	// volume according to the credential source.
+	Token *Token
+	// Certificate is a cert-manager Certificate the controller creates next to the ConfigMap.
+	Certificate *Certificate
+	// ServiceAccountAnnotations and ServiceAccountLabels are applied to the
+	// Kubernetes service account of the Deployment. They are used by the
+	// native workload identity of managed clusters.
+	ServiceAccountAnnotations map[string]string
+	ServiceAccountLabels      map[string]string
+	// PodLabels and PodAnnotations are applied to the pod template.
+	PodLabels      map[string]string
+	PodAnnotations map[string]string
+}
+
+// Certificate describes a cert-manager Certificate for the x509 credential source.
+type Certificate struct {
+	Name       string
+	SecretName string
+	CommonName string
+	IssuerRef  k8sv1alpha1.X509IssuerReference
+	Duration   *metav1.Duration
+}
+
+// Token describes the subject tokens a backend expects in a volume.
+type Token struct {
+	VolumeName        string
+	ExpirationSeconds int64
+	Projections       []TokenProjection
+}
+
+type TokenProjection struct {
+	Audience string
+	// Path is the file name of the token inside the volume.
+	Path string
+}
+
+// SingleToken returns a Token with one projection and the default expiration.
+func SingleToken(volumeName, audience, path string) *Token {
+	return &Token{
+		VolumeName:        volumeName,
+		ExpirationSeconds: TOKEN_EXPIRATION_SEC,
+		Projections: []TokenProjection{
+			{Audience: audience, Path: path},
+		},
+	}
+}
+
+var backends = map[k8sv1alpha1.ProviderTargetType]Backend{}
+
+// Register makes a backend available for the target and hooks its
+// validation into the Provider webhook.
+func Register(target k8sv1alpha1.ProviderTargetType, b Backend) {
+	backends[target] = b
+	k8sv1alpha1.RegisterTargetValidator(target, b.Validate)
+}
+
+// Get returns the backend registered for the target.
+func Get(target k8sv1alpha1.ProviderTargetType) (Backend, error) {
+	b, ok := backends[target]
+	if !ok {
+		return nil, fmt.Errorf("unsupported provider target type %s", target)
+	}
+	return b, nil
+}
+
+// Render returns the ConfigMap data and the Workload of the WorkloadIdentity
+// with the token materialized by its credential source.
+func Render(b Backend, wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider, configMapName string) (map[string]string, *Workload, error) {
+	data, err := b.ConfigData(wi, pr)
+	if err != nil {
+		return nil, nil, err
+	}
+	w := b.Workload(wi, pr, configMapName)
+	if w.Token == nil {
+		return data, w, nil
+	}
+	source := EffectiveCredentialSource(wi, pr)
+	switch source.Type {
+	case k8sv1alpha1.CredentialSourceTypeSPIFFE:
+		applySPIFFE(source.SPIFFE, data, w, configMapName)
+	case k8sv1alpha1.CredentialSourceTypeX509:
+		if pr.Spec.Target != k8sv1alpha1.ProviderTargetTypeGCP {
+			return nil, nil, fmt.Errorf("x509 credential source is not supported by provider target %s", pr.Spec.Target)
+		}
+		if source.X509 == nil {
+			return nil, nil, fmt.Errorf("x509 credential source requires x509 settings")
+		}
+		applyX509(source.X509, wi, w)
+	default:
+		w.Volumes = append(w.Volumes, ServiceAccountTokenVolume(w.Token))
+	}
+	return data, w, nil
+}
+
+// ServiceAccountTokenVolume returns a projected service account token volume.
+func ServiceAccountTokenVolume(token *Token) *corev1apply.VolumeApplyConfiguration {
+	sources := make([]*corev1apply.VolumeProjectionApplyConfiguration, 0, len(token.Projections))
+	for _, projection := range token.Projections {
+		sources = append(sources, corev1apply.VolumeProjection().
+			WithServiceAccountToken(
+				corev1apply.ServiceAccountTokenProjection().
+					WithAudience(projection.Audience).
+					WithExpirationSeconds(token.ExpirationSeconds).
+					WithPath(projection.Path),
+			),
+		)
+	}
+	return corev1apply.Volume().
+		WithName(token.VolumeName).
+		WithProjected(
+			corev1apply.ProjectedVolumeSource().
+				WithSources(sources...),
+		)
+}
+
+func hasVolume(w *Workload, name string) bool {
+	for _, v := range w.Volumes {
+		if *v.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ConfigMapVolume returns a volume of the managed ConfigMap.
+func ConfigMapVolume(name string) *corev1apply.VolumeApplyConfiguration {
+	return corev1apply.Volume().
+		WithName(name).
+		WithConfigMap(corev1apply.ConfigMapVolumeSource().
+			WithName(name),
+		)
+}
+
+// ReadOnlyMount returns a read only mount of the volume.
+func ReadOnlyMount(name, path string) *corev1apply.VolumeMountApplyConfiguration {
+	return corev1apply.VolumeMount().
+		WithName(name).
+		WithMountPath(path).
+		WithReadOnly(true)
+}
+
+// Env returns an environment variable with a literal value.
+func Env(name, value string) *corev1apply.EnvVarApplyConfiguration {
+	return corev1apply.EnvVar().
+		WithName(name).
+		WithValue(value)
+}
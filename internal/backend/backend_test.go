@@ -0,0 +1,161 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	k8sv1alpha1 "github.com/piny940/kwimount/api/v1alpha1"
+)
+
+func envMap(w *Workload) map[string]string {
+	env := make(map[string]string, len(w.Env))
+	for _, e := range w.Env {
+		env[*e.Name] = *e.Value
+	}
+	return env
+}
+
+var _ = Describe("Backend", func() {
+	Context("When looking up a backend", func() {
+		It("Should find a backend for every target", func() {
+			for _, target := range k8sv1alpha1.AllProviderTargetTypes {
+				b, err := Get(target)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(b).NotTo(BeNil())
+			}
+		})
+
+		It("Should fail for an unknown target", func() {
+			_, err := Get("unknown")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("When rendering an azure workload", func() {
+		It("Should inject the azure identity environment", func() {
+			wi := &k8sv1alpha1.WorkloadIdentity{
+				ObjectMeta: metav1.ObjectMeta{Name: "wi", Namespace: "default"},
+				Spec: k8sv1alpha1.WorkloadIdentitySpec{
+					Deployment: "app",
+					Azure:      &k8sv1alpha1.WorkloadIdentityAzure{ClientID: "client-id"},
+				},
+			}
+			pr := &k8sv1alpha1.Provider{
+				Spec: k8sv1alpha1.ProviderSpec{
+					Target: k8sv1alpha1.ProviderTargetTypeAzure,
+					Azure: &k8sv1alpha1.AzureProvider{
+						TenantID: "tenant-id",
+						Cloud:    k8sv1alpha1.AzureCloudChina,
+					},
+				},
+			}
+			b, err := Get(pr.Spec.Target)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(b.ValidateWorkloadIdentity(wi, pr)).To(Succeed())
+
+			_, w, err := Render(b, wi, pr, "conf")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(envMap(w)).To(Equal(map[string]string{
+				AZURE_CLIENT_ID_ENV:            "client-id",
+				AZURE_TENANT_ID_ENV:            "tenant-id",
+				AZURE_FEDERATED_TOKEN_FILE_ENV: AZURE_TOKEN_MOUNT_PATH + AZURE_TOKEN_PATH,
+				AZURE_AUTHORITY_HOST_ENV:       "https://login.chinacloudapi.cn/",
+			}))
+			Expect(w.Volumes).To(HaveLen(1))
+			Expect(*w.Volumes[0].Projected.Sources[0].ServiceAccountToken.Audience).To(Equal(AZURE_TOKEN_AUDIENCE))
+		})
+
+		It("Should reject a WorkloadIdentity without a client ID", func() {
+			b, err := Get(k8sv1alpha1.ProviderTargetTypeAzure)
+			Expect(err).NotTo(HaveOccurred())
+			err = b.ValidateWorkloadIdentity(&k8sv1alpha1.WorkloadIdentity{}, &k8sv1alpha1.Provider{
+				Spec: k8sv1alpha1.ProviderSpec{
+					Target: k8sv1alpha1.ProviderTargetTypeAzure,
+					Azure:  &k8sv1alpha1.AzureProvider{TenantID: "tenant-id"},
+				},
+			})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("When rendering a native workload", func() {
+		It("Should annotate the service account for gke-native", func() {
+			wi := &k8sv1alpha1.WorkloadIdentity{
+				ObjectMeta: metav1.ObjectMeta{Name: "wi", Namespace: "default"},
+				Spec: k8sv1alpha1.WorkloadIdentitySpec{
+					Deployment:           "app",
+					TargetServiceAccount: "sa@project.iam.gserviceaccount.com",
+				},
+			}
+			pr := &k8sv1alpha1.Provider{
+				Spec: k8sv1alpha1.ProviderSpec{
+					Target:  k8sv1alpha1.ProviderTargetTypeGKENative,
+					Project: k8sv1alpha1.Project{Name: "project", Number: "123456"},
+				},
+			}
+			b, err := Get(pr.Spec.Target)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(b.ValidateWorkloadIdentity(wi, pr)).To(Succeed())
+
+			_, w, err := Render(b, wi, pr, "conf")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(w.Volumes).To(BeEmpty())
+			Expect(w.Env).To(BeEmpty())
+			Expect(w.ServiceAccountAnnotations).To(Equal(map[string]string{
+				GKE_SERVICE_ACCOUNT_ANNOTATION: "sa@project.iam.gserviceaccount.com",
+			}))
+
+			wi.Spec.TargetServiceAccount = ""
+			wi.Spec.GCP = &k8sv1alpha1.WorkloadIdentityGCP{AccessMode: k8sv1alpha1.GCPAccessModeDirect}
+			_, w, err = Render(b, wi, pr, "conf")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(w.ServiceAccountAnnotations).To(BeEmpty())
+			Expect(b.(PrincipalReporter).Principal(wi, pr, "app-sa")).To(Equal(
+				"principal://iam.googleapis.com/projects/123456/locations/global/workloadIdentityPools/project.svc.id.goog/subject/ns/default/sa/app-sa"))
+		})
+
+		It("Should annotate the service account and label the pods for aks-native", func() {
+			wi := &k8sv1alpha1.WorkloadIdentity{
+				ObjectMeta: metav1.ObjectMeta{Name: "wi", Namespace: "default"},
+				Spec: k8sv1alpha1.WorkloadIdentitySpec{
+					Deployment: "app",
+					Azure:      &k8sv1alpha1.WorkloadIdentityAzure{ClientID: "client-id"},
+				},
+			}
+			pr := &k8sv1alpha1.Provider{
+				Spec: k8sv1alpha1.ProviderSpec{Target: k8sv1alpha1.ProviderTargetTypeAKSNative},
+			}
+			b, err := Get(pr.Spec.Target)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(b.ValidateWorkloadIdentity(wi, pr)).To(Succeed())
+
+			_, w, err := Render(b, wi, pr, "conf")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(w.Volumes).To(BeEmpty())
+			Expect(w.ServiceAccountAnnotations).To(Equal(map[string]string{AKS_CLIENT_ID_ANNOTATION: "client-id"}))
+			Expect(w.PodLabels).To(Equal(map[string]string{AKS_USE_LABEL: "true"}))
+
+			pr.Spec.Azure = &k8sv1alpha1.AzureProvider{TenantID: "tenant-id"}
+			_, w, err = Render(b, wi, pr, "conf")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(w.ServiceAccountAnnotations).To(HaveKeyWithValue(AKS_TENANT_ID_ANNOTATION, "tenant-id"))
+		})
+	})
+})
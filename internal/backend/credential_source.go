@@ -0,0 +1,151 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+
+	k8sv1alpha1 "github.com/piny940/kwimount/api/v1alpha1"
+)
+
+const (
+	SPIFFE_WORKLOAD_API_VOLUME_NAME  = "kwimount-spiffe-workload-api"
+	SPIFFE_WORKLOAD_API_MOUNT_PATH   = "/spiffe-workload-api/"
+	SPIFFE_HELPER_CONTAINER_NAME     = "kwimount-spiffe-helper"
+	SPIFFE_HELPER_TOKEN_MOUNT_PATH   = "/var/run/kwimount-spiffe-helper/"
+	SPIFFE_HELPER_CONFIG_MOUNT_PATH  = "/etc/kwimount-spiffe-helper/"
+	SPIFFE_HELPER_CONFIG_FILE_NAME   = "spiffe-helper.conf"
+	SPIFFE_HELPER_JWT_SVID_CONF_BASE = `  {
+    jwt_audience       = %q
+    jwt_svid_file_name = %q
+  },
+`
+	SPIFFE_HELPER_CONF_BASE = `agent_address = %q
+cmd           = ""
+cmd_args      = ""
+cert_dir      = %q
+daemon_mode   = true
+jwt_svids = [
+%s]
+`
+	X509_VOLUME_NAME = "kwimount-x509"
+	X509_MOUNT_PATH  = "/var/run/kwimount-x509/"
+	X509_CERT_PATH   = "tls.crt"
+	X509_KEY_PATH    = "tls.key"
+)
+
+// EffectiveCredentialSource returns the credential source of the
+// WorkloadIdentity, falling back to the one of the Provider.
+func EffectiveCredentialSource(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider) *k8sv1alpha1.CredentialSource {
+	source := &k8sv1alpha1.CredentialSource{}
+	if pr.Spec.CredentialSource != nil {
+		source = pr.Spec.CredentialSource.DeepCopy()
+	}
+	if wi.Spec.CredentialSource != nil {
+		source = wi.Spec.CredentialSource.DeepCopy()
+	}
+	source.Default()
+	return source
+}
+
+// X509SecretName returns the name of the Secret holding the client certificate.
+func X509SecretName(wi *k8sv1alpha1.WorkloadIdentity, x509 *k8sv1alpha1.X509CredentialSource) string {
+	if x509.SecretName != "" {
+		return x509.SecretName
+	}
+	return fmt.Sprintf("kwimount-%s-%s-cert", wi.Name, wi.Spec.Deployment)
+}
+
+// X509CommonName returns the common name of the Certificate created for the WorkloadIdentity.
+func X509CommonName(wi *k8sv1alpha1.WorkloadIdentity) string {
+	return wi.Namespace + "/" + wi.Name
+}
+
+// applyX509 replaces the projected service account token with the client
+// certificate stored in a kubernetes.io/tls Secret. The backend is expected to
+// reference the certificate at X509_MOUNT_PATH in its configuration.
+func applyX509(x509 *k8sv1alpha1.X509CredentialSource, wi *k8sv1alpha1.WorkloadIdentity, w *Workload) {
+	secretName := X509SecretName(wi, x509)
+	w.VolumeMounts = slices.DeleteFunc(w.VolumeMounts, func(m *corev1apply.VolumeMountApplyConfiguration) bool {
+		return *m.Name == w.Token.VolumeName
+	})
+	w.VolumeMounts = append(w.VolumeMounts, ReadOnlyMount(X509_VOLUME_NAME, X509_MOUNT_PATH))
+	w.Volumes = append(w.Volumes, corev1apply.Volume().
+		WithName(X509_VOLUME_NAME).
+		WithSecret(corev1apply.SecretVolumeSource().
+			WithSecretName(secretName),
+		),
+	)
+	if x509.IssuerRef != nil {
+		w.Certificate = &Certificate{
+			Name:       secretName,
+			SecretName: secretName,
+			CommonName: X509CommonName(wi),
+			IssuerRef:  *x509.IssuerRef,
+			Duration:   x509.Duration,
+		}
+	}
+}
+
+// applySPIFFE replaces the projected service account token with JWT-SVIDs.
+// The SPIFFE CSI driver only exposes the Workload API socket, so a
+// spiffe-helper sidecar fetches the JWT-SVIDs into a shared volume mounted
+// where the backend expects its tokens.
+func applySPIFFE(spiffe *k8sv1alpha1.SPIFFECredentialSource, data map[string]string, w *Workload, configMapName string) {
+	var svids strings.Builder
+	for _, projection := range w.Token.Projections {
+		svids.WriteString(fmt.Sprintf(SPIFFE_HELPER_JWT_SVID_CONF_BASE, projection.Audience, projection.Path))
+	}
+	data[SPIFFE_HELPER_CONFIG_FILE_NAME] = fmt.Sprintf(SPIFFE_HELPER_CONF_BASE,
+		SPIFFE_WORKLOAD_API_MOUNT_PATH+spiffe.SocketPath,
+		SPIFFE_HELPER_TOKEN_MOUNT_PATH,
+		svids.String(),
+	)
+
+	if !hasVolume(w, configMapName) {
+		w.Volumes = append(w.Volumes, ConfigMapVolume(configMapName))
+	}
+	w.Volumes = append(w.Volumes,
+		corev1apply.Volume().
+			WithName(w.Token.VolumeName).
+			WithEmptyDir(corev1apply.EmptyDirVolumeSource().
+				WithMedium("Memory"),
+			),
+		corev1apply.Volume().
+			WithName(SPIFFE_WORKLOAD_API_VOLUME_NAME).
+			WithCSI(corev1apply.CSIVolumeSource().
+				WithDriver(spiffe.Driver).
+				WithReadOnly(true),
+			),
+	)
+	w.Containers = append(w.Containers, corev1apply.Container().
+		WithName(SPIFFE_HELPER_CONTAINER_NAME).
+		WithImage(spiffe.HelperImage).
+		WithArgs("-config", SPIFFE_HELPER_CONFIG_MOUNT_PATH+SPIFFE_HELPER_CONFIG_FILE_NAME).
+		WithVolumeMounts(
+			ReadOnlyMount(SPIFFE_WORKLOAD_API_VOLUME_NAME, SPIFFE_WORKLOAD_API_MOUNT_PATH),
+			ReadOnlyMount(configMapName, SPIFFE_HELPER_CONFIG_MOUNT_PATH),
+			corev1apply.VolumeMount().
+				WithName(w.Token.VolumeName).
+				WithMountPath(SPIFFE_HELPER_TOKEN_MOUNT_PATH),
+		),
+	)
+}
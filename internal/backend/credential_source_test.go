@@ -0,0 +1,176 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+
+	k8sv1alpha1 "github.com/piny940/kwimount/api/v1alpha1"
+)
+
+func findVolume(w *Workload, name string) *corev1apply.VolumeApplyConfiguration {
+	for _, v := range w.Volumes {
+		if *v.Name == name {
+			return v
+		}
+	}
+	return nil
+}
+
+var _ = Describe("Credential Source", func() {
+	var (
+		wi *k8sv1alpha1.WorkloadIdentity
+		pr *k8sv1alpha1.Provider
+	)
+
+	BeforeEach(func() {
+		wi = &k8sv1alpha1.WorkloadIdentity{
+			ObjectMeta: metav1.ObjectMeta{Name: "wi", Namespace: "default"},
+			Spec: k8sv1alpha1.WorkloadIdentitySpec{
+				Deployment:           "app",
+				TargetServiceAccount: "sa@project.iam.gserviceaccount.com",
+			},
+		}
+		pr = &k8sv1alpha1.Provider{
+			Spec: k8sv1alpha1.ProviderSpec{
+				Target:     k8sv1alpha1.ProviderTargetTypeGCP,
+				PoolID:     "pool",
+				Location:   "global",
+				ProviderID: "provider",
+				Project:    k8sv1alpha1.Project{Name: "project", Number: "123"},
+				CredentialSource: &k8sv1alpha1.CredentialSource{
+					Type: k8sv1alpha1.CredentialSourceTypeSPIFFE,
+				},
+			},
+		}
+	})
+
+	It("Should fetch JWT-SVIDs through the SPIFFE CSI driver", func() {
+		b, err := Get(pr.Spec.Target)
+		Expect(err).NotTo(HaveOccurred())
+		data, w, err := Render(b, wi, pr, "conf")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(data).To(HaveKey(GCP_CONFIGURATION_FILE_NAME))
+		Expect(data[SPIFFE_HELPER_CONFIG_FILE_NAME]).To(Equal(`agent_address = "/spiffe-workload-api/spire-agent.sock"
+cmd           = ""
+cmd_args      = ""
+cert_dir      = "/var/run/kwimount-spiffe-helper/"
+daemon_mode   = true
+jwt_svids = [
+  {
+    jwt_audience       = "https://iam.googleapis.com/projects/123/locations/global/workloadIdentityPools/pool/providers/provider"
+    jwt_svid_file_name = "token"
+  },
+]
+`))
+
+		token := findVolume(w, GCP_TOKEN_VOLUME_NAME)
+		Expect(token).NotTo(BeNil())
+		Expect(token.Projected).To(BeNil())
+		Expect(token.EmptyDir).NotTo(BeNil())
+		csi := findVolume(w, SPIFFE_WORKLOAD_API_VOLUME_NAME)
+		Expect(csi).NotTo(BeNil())
+		Expect(*csi.CSI.Driver).To(Equal("csi.spiffe.io"))
+		Expect(w.Containers).To(HaveLen(1))
+		Expect(*w.Containers[0].Name).To(Equal(SPIFFE_HELPER_CONTAINER_NAME))
+	})
+
+	It("Should prefer the credential source of the WorkloadIdentity", func() {
+		wi.Spec.CredentialSource = &k8sv1alpha1.CredentialSource{
+			Type: k8sv1alpha1.CredentialSourceTypeServiceAccountToken,
+		}
+		b, err := Get(pr.Spec.Target)
+		Expect(err).NotTo(HaveOccurred())
+		data, w, err := Render(b, wi, pr, "conf")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(data).NotTo(HaveKey(SPIFFE_HELPER_CONFIG_FILE_NAME))
+		Expect(w.Containers).To(BeEmpty())
+		token := findVolume(w, GCP_TOKEN_VOLUME_NAME)
+		Expect(token).NotTo(BeNil())
+		Expect(token.Projected).NotTo(BeNil())
+	})
+
+	It("Should add the ConfigMap volume for backends without one", func() {
+		pr.Spec.Target = k8sv1alpha1.ProviderTargetTypeAWS
+		pr.Spec.AWS = &k8sv1alpha1.AWSProvider{RoleARN: "arn:aws:iam::123456789012:role/r", Region: "us-east-1"}
+		b, err := Get(pr.Spec.Target)
+		Expect(err).NotTo(HaveOccurred())
+		_, w, err := Render(b, wi, pr, "conf")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(findVolume(w, "conf")).NotTo(BeNil())
+		Expect(findVolume(w, AWS_TOKEN_VOLUME_NAME).EmptyDir).NotTo(BeNil())
+	})
+
+	It("Should mount a client certificate created by cert-manager", func() {
+		pr.Spec.CredentialSource = &k8sv1alpha1.CredentialSource{
+			Type: k8sv1alpha1.CredentialSourceTypeX509,
+			X509: &k8sv1alpha1.X509CredentialSource{
+				IssuerRef: &k8sv1alpha1.X509IssuerReference{Name: "issuer", Kind: "ClusterIssuer", Group: "cert-manager.io"},
+			},
+		}
+		b, err := Get(pr.Spec.Target)
+		Expect(err).NotTo(HaveOccurred())
+		data, w, err := Render(b, wi, pr, "conf")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(data).To(HaveKey(GCP_CERTIFICATE_CONFIG_FILE_NAME))
+		Expect(findVolume(w, GCP_TOKEN_VOLUME_NAME)).To(BeNil())
+		for _, m := range w.VolumeMounts {
+			Expect(*m.Name).NotTo(Equal(GCP_TOKEN_VOLUME_NAME))
+		}
+		secret := findVolume(w, X509_VOLUME_NAME)
+		Expect(secret).NotTo(BeNil())
+		Expect(*secret.Secret.SecretName).To(Equal("kwimount-wi-app-cert"))
+		Expect(w.Certificate).To(Equal(&Certificate{
+			Name:       "kwimount-wi-app-cert",
+			SecretName: "kwimount-wi-app-cert",
+			CommonName: "default/wi",
+			IssuerRef:  k8sv1alpha1.X509IssuerReference{Name: "issuer", Kind: "ClusterIssuer", Group: "cert-manager.io"},
+		}))
+	})
+
+	It("Should mount an existing client certificate", func() {
+		pr.Spec.CredentialSource = &k8sv1alpha1.CredentialSource{
+			Type: k8sv1alpha1.CredentialSourceTypeX509,
+			X509: &k8sv1alpha1.X509CredentialSource{SecretName: "existing"},
+		}
+		b, err := Get(pr.Spec.Target)
+		Expect(err).NotTo(HaveOccurred())
+		_, w, err := Render(b, wi, pr, "conf")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*findVolume(w, X509_VOLUME_NAME).Secret.SecretName).To(Equal("existing"))
+		Expect(w.Certificate).To(BeNil())
+	})
+
+	It("Should reject a client certificate for other targets", func() {
+		pr.Spec.Target = k8sv1alpha1.ProviderTargetTypeAWS
+		pr.Spec.AWS = &k8sv1alpha1.AWSProvider{RoleARN: "arn:aws:iam::123456789012:role/r", Region: "us-east-1"}
+		pr.Spec.CredentialSource = &k8sv1alpha1.CredentialSource{
+			Type: k8sv1alpha1.CredentialSourceTypeX509,
+			X509: &k8sv1alpha1.X509CredentialSource{SecretName: "existing"},
+		}
+		b, err := Get(pr.Spec.Target)
+		Expect(err).NotTo(HaveOccurred())
+		_, _, err = Render(b, wi, pr, "conf")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,90 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"strings"
+
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+
+	k8sv1alpha1 "github.com/piny940/kwimount/api/v1alpha1"
+)
+
+const (
+	GCLOUD_CONFIG_VOLUME_NAME      = "kwimount-gcloud-config"
+	GCLOUD_CONFIG_MOUNT_PATH       = "/var/run/kwimount-gcloud/"
+	GCLOUD_CONFIGURATION_NAME      = "kwimount"
+	GCLOUD_ACTIVE_CONFIG_FILE_NAME = "gcloud-active-config"
+	GCLOUD_CONFIGURATION_FILE_NAME = "gcloud-config-kwimount"
+	CLOUDSDK_CONFIG_ENV            = "CLOUDSDK_CONFIG"
+	CLOUDSDK_CREDENTIAL_FILE_ENV   = "CLOUDSDK_AUTH_CREDENTIAL_FILE_OVERRIDE"
+)
+
+func gcloudEnabled(wi *k8sv1alpha1.WorkloadIdentity) bool {
+	return wi.Spec.GCP != nil && wi.Spec.GCP.InjectGcloudConfig
+}
+
+// gcloudConfigData renders the files of a gcloud configuration named kwimount.
+// The values are validated by the webhooks not to contain line breaks.
+func gcloudConfigData(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider) map[string]string {
+	var conf strings.Builder
+	conf.WriteString("[auth]\n")
+	conf.WriteString("credential_file_override = " + GCP_CONFIGURATION_MOUNT_PATH + GCP_CONFIGURATION_FILE_NAME + "\n")
+	conf.WriteString("\n[core]\n")
+	conf.WriteString("universe_domain = " + gcpUniverseDomain(pr) + "\n")
+	if wi.Spec.GCP.InjectProjectEnv {
+		conf.WriteString("project = " + pr.Spec.Project.Name + "\n")
+	}
+	if wi.Spec.GCP.QuotaProjectID != "" {
+		conf.WriteString("\n[billing]\n")
+		conf.WriteString("quota_project = " + wi.Spec.GCP.QuotaProjectID + "\n")
+	}
+	return map[string]string{
+		GCLOUD_ACTIVE_CONFIG_FILE_NAME: GCLOUD_CONFIGURATION_NAME,
+		GCLOUD_CONFIGURATION_FILE_NAME: conf.String(),
+	}
+}
+
+// applyGcloud points the gcloud CLI at the credential configuration. gcloud
+// writes logs and caches into its configuration directory, so the directory
+// is an emptyDir and only the configuration files are mounted from the
+// ConfigMap.
+func applyGcloud(w *Workload, configMapName string) {
+	w.Env = append(w.Env,
+		Env(CLOUDSDK_CONFIG_ENV, GCLOUD_CONFIG_MOUNT_PATH),
+		Env(CLOUDSDK_CREDENTIAL_FILE_ENV, GCP_CONFIGURATION_MOUNT_PATH+GCP_CONFIGURATION_FILE_NAME),
+	)
+	w.VolumeMounts = append(w.VolumeMounts,
+		corev1apply.VolumeMount().
+			WithName(GCLOUD_CONFIG_VOLUME_NAME).
+			WithMountPath(GCLOUD_CONFIG_MOUNT_PATH),
+		corev1apply.VolumeMount().
+			WithName(configMapName).
+			WithMountPath(GCLOUD_CONFIG_MOUNT_PATH+"active_config").
+			WithSubPath(GCLOUD_ACTIVE_CONFIG_FILE_NAME).
+			WithReadOnly(true),
+		corev1apply.VolumeMount().
+			WithName(configMapName).
+			WithMountPath(GCLOUD_CONFIG_MOUNT_PATH+"configurations/config_"+GCLOUD_CONFIGURATION_NAME).
+			WithSubPath(GCLOUD_CONFIGURATION_FILE_NAME).
+			WithReadOnly(true),
+	)
+	w.Volumes = append(w.Volumes, corev1apply.Volume().
+		WithName(GCLOUD_CONFIG_VOLUME_NAME).
+		WithEmptyDir(corev1apply.EmptyDirVolumeSource()),
+	)
+}
@@ -0,0 +1,200 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"fmt"
+	"maps"
+	"net/url"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+
+	k8sv1alpha1 "github.com/piny940/kwimount/api/v1alpha1"
+)
+
+const (
+	GCP_TOKEN_MOUNT_PATH         = "/var/run/kwimount-gcp-service-account/"
+	GCP_TOKEN_PATH               = "token"
+	GCP_CONFIGURATION_MOUNT_PATH = "/etc/kwimount-gcp-workload-identity/"
+	GCP_CONFIGURATION_FILE_NAME  = "gcp-credential-configuration.json"
+	GCP_TOKEN_VOLUME_NAME        = "kwimount-gcp-token"
+	GCP_TOKEN_AUDIENCE           = "https://iam.%s/projects/%s/locations/%s/workloadIdentityPools/%s/providers/%s"
+	GCP_PRINCIPAL_BASE           = "principal://iam.%s/projects/%s/locations/%s/workloadIdentityPools/%s/subject/%s"
+	GOOGLE_CREDENTIALS_ENV       = "GOOGLE_APPLICATION_CREDENTIALS"
+	GOOGLE_CLOUD_PROJECT_ENV     = "GOOGLE_CLOUD_PROJECT"
+	CLOUDSDK_CORE_PROJECT_ENV    = "CLOUDSDK_CORE_PROJECT"
+)
+
+type gcp struct{}
+
+var _ Backend = &gcp{}
+var _ PrincipalReporter = &gcp{}
+var _ ClaimChecker = &gcp{}
+
+func init() {
+	Register(k8sv1alpha1.ProviderTargetTypeGCP, &gcp{})
+}
+
+func (g *gcp) Validate(spec *k8sv1alpha1.ProviderSpec) error {
+	if spec.PoolID == "" {
+		return field.Invalid(field.NewPath("spec", "poolID"), spec.PoolID, "poolID cannot be empty")
+	}
+	if spec.ProviderID == "" {
+		return field.Invalid(field.NewPath("spec", "providerID"), spec.ProviderID, "providerID cannot be empty")
+	}
+	if spec.Project.Number == "" {
+		return field.Invalid(field.NewPath("spec", "project", "number"), spec.Project.Number, "project number cannot be empty")
+	}
+	if spec.Project.Name == "" {
+		return field.Invalid(field.NewPath("spec", "project", "id"), spec.Project.Name, "project id cannot be empty")
+	}
+	if err := k8sv1alpha1.ValidateSingleLine(field.NewPath("spec", "project", "id"), spec.Project.Name); err != nil {
+		return err
+	}
+	if spec.UniverseDomain != "" {
+		if errs := validation.IsDNS1123Subdomain(spec.UniverseDomain); len(errs) > 0 {
+			return field.Invalid(field.NewPath("spec", "universeDomain"), spec.UniverseDomain, strings.Join(errs, ", "))
+		}
+	}
+	if err := validateGCPAttributes(spec); err != nil {
+		return err
+	}
+	if spec.TokenURL != "" {
+		if err := validateHTTPSURL("tokenURL", spec.TokenURL); err != nil {
+			return field.Invalid(field.NewPath("spec", "tokenURL"), spec.TokenURL, err.Error())
+		}
+	}
+	if spec.ImpersonationHost != "" {
+		u, err := url.Parse("https://" + spec.ImpersonationHost)
+		if err != nil || u.Host != spec.ImpersonationHost || len(validation.IsDNS1123Subdomain(u.Hostname())) > 0 {
+			return field.Invalid(field.NewPath("spec", "impersonationHost"), spec.ImpersonationHost, "impersonationHost must be a host name with an optional port")
+		}
+	}
+	return nil
+}
+
+func (g *gcp) ValidateWorkloadIdentity(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider) error {
+	if gcpAccessMode(wi) == k8sv1alpha1.GCPAccessModeImpersonation && wi.Spec.TargetServiceAccount == "" {
+		return fmt.Errorf("targetServiceAccount is required for provider target %s", pr.Spec.Target)
+	}
+	return nil
+}
+
+func (g *gcp) Audience(pr *k8sv1alpha1.Provider) string {
+	if pr.Spec.Audience != "" {
+		return pr.Spec.Audience
+	}
+	return fmt.Sprintf(GCP_TOKEN_AUDIENCE, gcpUniverseDomain(pr), pr.Spec.Project.Number, pr.Spec.Location, pr.Spec.PoolID, pr.Spec.ProviderID)
+}
+
+func (g *gcp) ConfigData(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider) (map[string]string, error) {
+	conf, err := marshalGCPCredentialConfig(wi, pr)
+	if err != nil {
+		return nil, err
+	}
+	data := map[string]string{GCP_CONFIGURATION_FILE_NAME: conf}
+	if EffectiveCredentialSource(wi, pr).Type == k8sv1alpha1.CredentialSourceTypeX509 {
+		data[GCP_CERTIFICATE_CONFIG_FILE_NAME], err = marshalIndent(gcpCertificateConfig())
+		if err != nil {
+			return nil, err
+		}
+	}
+	if gcloudEnabled(wi) {
+		maps.Copy(data, gcloudConfigData(wi, pr))
+	}
+	return data, nil
+}
+
+func (g *gcp) Workload(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider, configMapName string) *Workload {
+	env := []*corev1apply.EnvVarApplyConfiguration{
+		Env(GOOGLE_CREDENTIALS_ENV, GCP_CONFIGURATION_MOUNT_PATH+GCP_CONFIGURATION_FILE_NAME),
+	}
+	if wi.Spec.GCP != nil && wi.Spec.GCP.InjectProjectEnv {
+		env = append(env,
+			Env(GOOGLE_CLOUD_PROJECT_ENV, pr.Spec.Project.Name),
+			Env(CLOUDSDK_CORE_PROJECT_ENV, pr.Spec.Project.Name),
+		)
+	}
+	w := &Workload{
+		Env: env,
+		VolumeMounts: []*corev1apply.VolumeMountApplyConfiguration{
+			ReadOnlyMount(GCP_TOKEN_VOLUME_NAME, GCP_TOKEN_MOUNT_PATH),
+			ReadOnlyMount(configMapName, GCP_CONFIGURATION_MOUNT_PATH),
+		},
+		Volumes: []*corev1apply.VolumeApplyConfiguration{
+			ConfigMapVolume(configMapName),
+		},
+		Token: SingleToken(GCP_TOKEN_VOLUME_NAME, g.Audience(pr), GCP_TOKEN_PATH),
+	}
+	if gcloudEnabled(wi) {
+		applyGcloud(w, configMapName)
+	}
+	return w
+}
+
+// Principal returns the principal identifier of the pods in the direct access
+// mode. The subject is mapped from the service account token claims by the attribute mapping.
+func (g *gcp) Principal(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider, serviceAccountName string) string {
+	if gcpAccessMode(wi) != k8sv1alpha1.GCPAccessModeDirect {
+		return ""
+	}
+	var subject string
+	switch source := EffectiveCredentialSource(wi, pr); source.Type {
+	case k8sv1alpha1.CredentialSourceTypeX509:
+		// The subject of an existing certificate is unknown. Created
+		// certificates are assumed to be mapped by the common name.
+		if source.X509.IssuerRef == nil {
+			return ""
+		}
+		subject = X509CommonName(wi)
+	case k8sv1alpha1.CredentialSourceTypeServiceAccountToken:
+		var err error
+		subject, err = gcpSubject(&pr.Spec, kubernetesTokenClaims(wi.Namespace, serviceAccountName, g.Audience(pr)))
+		if err != nil {
+			return ""
+		}
+	default:
+		return ""
+	}
+	return fmt.Sprintf(GCP_PRINCIPAL_BASE,
+		gcpUniverseDomain(pr),
+		pr.Spec.Project.Number,
+		pr.Spec.Location,
+		pr.Spec.PoolID,
+		subject,
+	)
+}
+
+// CheckClaims evaluates the attribute condition against the claims of the
+// service account token. The claims of other credential sources are not known
+// to the controller, so ErrClaimsUnknown is returned for them.
+func (g *gcp) CheckClaims(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider, serviceAccountName string) error {
+	if source := EffectiveCredentialSource(wi, pr).Type; source != k8sv1alpha1.CredentialSourceTypeServiceAccountToken {
+		return fmt.Errorf("%w: the %s credential source is not checked", ErrClaimsUnknown, source)
+	}
+	return evaluateGCPCondition(&pr.Spec, kubernetesTokenClaims(wi.Namespace, serviceAccountName, g.Audience(pr)))
+}
+
+func gcpAccessMode(wi *k8sv1alpha1.WorkloadIdentity) k8sv1alpha1.GCPAccessMode {
+	if wi.Spec.GCP == nil || wi.Spec.GCP.AccessMode == "" {
+		return k8sv1alpha1.GCPAccessModeImpersonation
+	}
+	return wi.Spec.GCP.AccessMode
+}
@@ -0,0 +1,269 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/interpreter"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	k8sv1alpha1 "github.com/piny940/kwimount/api/v1alpha1"
+)
+
+const (
+	GCP_ATTRIBUTE_GOOGLE_PREFIX = "google."
+	GCP_ATTRIBUTE_CUSTOM_PREFIX = "attribute."
+	GCP_ATTRIBUTE_SUBJECT       = "google.subject"
+)
+
+var (
+	gcpGoogleAttributes     = []string{"subject", "groups", "display_name", "profile_photo"}
+	gcpCustomAttributeRegex = regexp.MustCompile(`^[a-z_][a-z0-9_]{0,99}$`)
+	gcpDefaultMapping       = map[string]string{GCP_ATTRIBUTE_SUBJECT: "assertion.sub"}
+	// kubernetesUnknownClaims are carried by the tokens but depend on the
+	// cluster or the pod, so expressions reading them are left unknown.
+	kubernetesUnknownClaims = [][]string{
+		{"iss"}, {"iat"}, {"nbf"}, {"exp"}, {"jti"},
+		{"kubernetes.io", "pod"},
+		{"kubernetes.io", "node"},
+		{"kubernetes.io", "warnafter"},
+	}
+)
+
+// gcpAttributes is the result of the attribute mapping. Attributes whose
+// value depends on unknown claims are listed in unknown instead.
+type gcpAttributes struct {
+	google    map[string]any
+	attribute map[string]any
+	unknown   map[string]error
+}
+
+func gcpAttributeMapping(spec *k8sv1alpha1.ProviderSpec) map[string]string {
+	if len(spec.AttributeMapping) == 0 {
+		return gcpDefaultMapping
+	}
+	return spec.AttributeMapping
+}
+
+func newGCPMappingEnv() (*cel.Env, error) {
+	return cel.NewEnv(cel.Variable("assertion", cel.DynType))
+}
+
+func newGCPConditionEnv() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Variable("assertion", cel.DynType),
+		cel.Variable("google", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("attribute", cel.MapType(cel.StringType, cel.DynType)),
+	)
+}
+
+func compileGCPExpression(env *cel.Env, expr string) (cel.Program, error) {
+	ast, issues := env.Compile(expr)
+	if issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	return env.Program(ast, cel.EvalOptions(cel.OptPartialEval))
+}
+
+// evalGCPExpression evaluates prg with the given patterns left unknown. It
+// returns an error wrapping ErrClaimsUnknown if the result cannot be decided,
+// either because it depends on an unknown claim or a claim is missing.
+func evalGCPExpression(prg cel.Program, vars map[string]any, unknowns []*interpreter.AttributePattern) (ref.Val, error) {
+	activation, err := cel.PartialVars(vars, unknowns...)
+	if err != nil {
+		return nil, err
+	}
+	out, _, err := prg.Eval(activation)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrClaimsUnknown, err)
+	}
+	if types.IsUnknown(out) {
+		return nil, fmt.Errorf("%w: the result depends on claims of the running pods", ErrClaimsUnknown)
+	}
+	return out, nil
+}
+
+func kubernetesUnknownClaimPatterns() []*interpreter.AttributePattern {
+	patterns := make([]*interpreter.AttributePattern, 0, len(kubernetesUnknownClaims))
+	for _, claim := range kubernetesUnknownClaims {
+		pattern := cel.AttributePattern("assertion")
+		for _, qualifier := range claim {
+			pattern = pattern.QualString(qualifier)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns
+}
+
+// validateGCPAttributes checks the keys of the attribute mapping and that
+// every expression compiles.
+func validateGCPAttributes(spec *k8sv1alpha1.ProviderSpec) error {
+	path := field.NewPath("spec", "attributeMapping")
+	if len(spec.AttributeMapping) > 0 {
+		if _, ok := spec.AttributeMapping[GCP_ATTRIBUTE_SUBJECT]; !ok {
+			return field.Required(path.Key(GCP_ATTRIBUTE_SUBJECT), "google.subject must be mapped")
+		}
+	}
+	mappingEnv, err := newGCPMappingEnv()
+	if err != nil {
+		return err
+	}
+	for key, expr := range spec.AttributeMapping {
+		switch {
+		case strings.HasPrefix(key, GCP_ATTRIBUTE_GOOGLE_PREFIX):
+			if !slices.Contains(gcpGoogleAttributes, strings.TrimPrefix(key, GCP_ATTRIBUTE_GOOGLE_PREFIX)) {
+				return field.Invalid(path.Key(key), key, fmt.Sprintf("google attributes must be one of %v", gcpGoogleAttributes))
+			}
+		case strings.HasPrefix(key, GCP_ATTRIBUTE_CUSTOM_PREFIX):
+			if !gcpCustomAttributeRegex.MatchString(strings.TrimPrefix(key, GCP_ATTRIBUTE_CUSTOM_PREFIX)) {
+				return field.Invalid(path.Key(key), key, "custom attribute names must match "+gcpCustomAttributeRegex.String())
+			}
+		default:
+			return field.Invalid(path.Key(key), key, "attributes must start with google. or attribute.")
+		}
+		if _, err := compileGCPExpression(mappingEnv, expr); err != nil {
+			return field.Invalid(path.Key(key), expr, err.Error())
+		}
+	}
+	if spec.AttributeCondition != "" {
+		conditionEnv, err := newGCPConditionEnv()
+		if err != nil {
+			return err
+		}
+		if _, err := compileGCPExpression(conditionEnv, spec.AttributeCondition); err != nil {
+			return field.Invalid(field.NewPath("spec", "attributeCondition"), spec.AttributeCondition, err.Error())
+		}
+	}
+	return nil
+}
+
+// kubernetesTokenClaims returns the claims of a projected service account
+// token of pods running as the service account. Claims that depend on the
+// cluster or the pod are not known in advance and are left out; see
+// kubernetesUnknownClaims.
+func kubernetesTokenClaims(namespace, serviceAccountName, audience string) map[string]any {
+	return map[string]any{
+		"sub": "system:serviceaccount:" + namespace + ":" + serviceAccountName,
+		"aud": []any{audience},
+		"kubernetes.io": map[string]any{
+			"namespace": namespace,
+			"serviceaccount": map[string]any{
+				"name": serviceAccountName,
+			},
+		},
+	}
+}
+
+// mapGCPAttributes evaluates the attribute mapping against the claims.
+func mapGCPAttributes(spec *k8sv1alpha1.ProviderSpec, claims map[string]any) (*gcpAttributes, error) {
+	env, err := newGCPMappingEnv()
+	if err != nil {
+		return nil, err
+	}
+	attrs := &gcpAttributes{google: map[string]any{}, attribute: map[string]any{}, unknown: map[string]error{}}
+	for key, expr := range gcpAttributeMapping(spec) {
+		prg, err := compileGCPExpression(env, expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid attribute mapping %s: %w", key, err)
+		}
+		out, err := evalGCPExpression(prg, map[string]any{"assertion": claims}, kubernetesUnknownClaimPatterns())
+		if err != nil {
+			attrs.unknown[key] = fmt.Errorf("unable to map %s: %w", key, err)
+			continue
+		}
+		if name, ok := strings.CutPrefix(key, GCP_ATTRIBUTE_GOOGLE_PREFIX); ok {
+			attrs.google[name] = out
+		} else {
+			attrs.attribute[strings.TrimPrefix(key, GCP_ATTRIBUTE_CUSTOM_PREFIX)] = out
+		}
+	}
+	return attrs, nil
+}
+
+// unknownPatterns returns the patterns of the attributes that could not be mapped.
+func (a *gcpAttributes) unknownPatterns() []*interpreter.AttributePattern {
+	patterns := make([]*interpreter.AttributePattern, 0, len(a.unknown))
+	for key := range a.unknown {
+		if name, ok := strings.CutPrefix(key, GCP_ATTRIBUTE_GOOGLE_PREFIX); ok {
+			patterns = append(patterns, cel.AttributePattern("google").QualString(name))
+		} else {
+			patterns = append(patterns, cel.AttributePattern("attribute").QualString(strings.TrimPrefix(key, GCP_ATTRIBUTE_CUSTOM_PREFIX)))
+		}
+	}
+	return patterns
+}
+
+// gcpSubject returns the google.subject mapped from the claims.
+func gcpSubject(spec *k8sv1alpha1.ProviderSpec, claims map[string]any) (string, error) {
+	attrs, err := mapGCPAttributes(spec, claims)
+	if err != nil {
+		return "", err
+	}
+	if err, ok := attrs.unknown[GCP_ATTRIBUTE_SUBJECT]; ok {
+		return "", err
+	}
+	subject, ok := attrs.google["subject"].(ref.Val)
+	if !ok || subject.Type() != types.StringType {
+		return "", fmt.Errorf("%s must be a string", GCP_ATTRIBUTE_SUBJECT)
+	}
+	return subject.Value().(string), nil
+}
+
+// evaluateGCPCondition returns an error if the claims would be rejected by the
+// attribute condition. The error wraps ErrClaimsUnknown when the condition
+// cannot be decided from the claims known in advance.
+func evaluateGCPCondition(spec *k8sv1alpha1.ProviderSpec, claims map[string]any) error {
+	attrs, err := mapGCPAttributes(spec, claims)
+	if err != nil {
+		return err
+	}
+	if spec.AttributeCondition == "" {
+		return nil
+	}
+	env, err := newGCPConditionEnv()
+	if err != nil {
+		return err
+	}
+	prg, err := compileGCPExpression(env, spec.AttributeCondition)
+	if err != nil {
+		return fmt.Errorf("invalid attribute condition: %w", err)
+	}
+	out, err := evalGCPExpression(prg, map[string]any{
+		"assertion": claims,
+		"google":    attrs.google,
+		"attribute": attrs.attribute,
+	}, append(kubernetesUnknownClaimPatterns(), attrs.unknownPatterns()...))
+	if err != nil {
+		return fmt.Errorf("unable to evaluate the attribute condition: %w", err)
+	}
+	allowed, err := out.ConvertToNative(reflect.TypeOf(true))
+	if err != nil {
+		return fmt.Errorf("attribute condition must evaluate to a bool: %w", err)
+	}
+	if !allowed.(bool) {
+		return fmt.Errorf("attribute condition %q is not satisfied by the token of %s", spec.AttributeCondition, claims["sub"])
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	k8sv1alpha1 "github.com/piny940/kwimount/api/v1alpha1"
+)
+
+var _ = Describe("GCP Attributes", func() {
+	var (
+		wi *k8sv1alpha1.WorkloadIdentity
+		pr *k8sv1alpha1.Provider
+	)
+
+	BeforeEach(func() {
+		wi = &k8sv1alpha1.WorkloadIdentity{
+			ObjectMeta: metav1.ObjectMeta{Name: "wi", Namespace: "team-a"},
+			Spec: k8sv1alpha1.WorkloadIdentitySpec{
+				Deployment: "app",
+				GCP:        &k8sv1alpha1.WorkloadIdentityGCP{AccessMode: k8sv1alpha1.GCPAccessModeDirect},
+			},
+		}
+		pr = &k8sv1alpha1.Provider{
+			Spec: k8sv1alpha1.ProviderSpec{
+				Target:     k8sv1alpha1.ProviderTargetTypeGCP,
+				PoolID:     "pool",
+				ProviderID: "provider",
+				Location:   "global",
+				Project:    k8sv1alpha1.Project{Name: "project", Number: "123456"},
+				AttributeMapping: map[string]string{
+					"google.subject":      "assertion.sub",
+					"attribute.namespace": `assertion["kubernetes.io"]["namespace"]`,
+				},
+				AttributeCondition: `attribute.namespace.startsWith("team-")`,
+			},
+		}
+	})
+
+	It("Should validate the mapping and the condition", func() {
+		b, err := Get(pr.Spec.Target)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(b.Validate(&pr.Spec)).To(Succeed())
+
+		spec := pr.Spec.DeepCopy()
+		delete(spec.AttributeMapping, "google.subject")
+		Expect(b.Validate(spec)).NotTo(Succeed())
+
+		spec = pr.Spec.DeepCopy()
+		spec.AttributeMapping["google.unknown"] = "assertion.sub"
+		Expect(b.Validate(spec)).NotTo(Succeed())
+
+		spec = pr.Spec.DeepCopy()
+		spec.AttributeMapping["attribute.Namespace"] = "assertion.sub"
+		Expect(b.Validate(spec)).NotTo(Succeed())
+
+		spec = pr.Spec.DeepCopy()
+		spec.AttributeCondition = "attribute.namespace =="
+		Expect(b.Validate(spec)).NotTo(Succeed())
+	})
+
+	It("Should accept workloads satisfying the condition", func() {
+		b, err := Get(pr.Spec.Target)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(b.(ClaimChecker).CheckClaims(wi, pr, "default")).To(Succeed())
+	})
+
+	It("Should reject workloads not satisfying the condition", func() {
+		wi.Namespace = "other"
+		b, err := Get(pr.Spec.Target)
+		Expect(err).NotTo(HaveOccurred())
+		err = b.(ClaimChecker).CheckClaims(wi, pr, "default")
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("system:serviceaccount:other:default"))
+	})
+
+	It("Should report claims unknown in advance", func() {
+		pr.Spec.AttributeCondition = `assertion["kubernetes.io"]["pod"]["name"] == "app"`
+		b, err := Get(pr.Spec.Target)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(b.(ClaimChecker).CheckClaims(wi, pr, "default")).To(MatchError(ErrClaimsUnknown))
+	})
+
+	It("Should report a condition on the issuer as unknown", func() {
+		pr.Spec.AttributeCondition = `assertion.iss == "https://kubernetes.default.svc.cluster.local"`
+		b, err := Get(pr.Spec.Target)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(b.(ClaimChecker).CheckClaims(wi, pr, "default")).To(MatchError(ErrClaimsUnknown))
+	})
+
+	It("Should report an attribute mapped from the pod claim as unknown", func() {
+		pr.Spec.AttributeMapping["attribute.pod"] = `assertion["kubernetes.io"]["pod"]["name"]`
+		pr.Spec.AttributeCondition = `attribute.pod.startsWith("app-")`
+		b, err := Get(pr.Spec.Target)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(b.(ClaimChecker).CheckClaims(wi, pr, "default")).To(MatchError(ErrClaimsUnknown))
+		Expect(b.(PrincipalReporter).Principal(wi, pr, "default")).NotTo(BeEmpty())
+	})
+
+	It("Should reject workloads when the known claims decide the condition", func() {
+		wi.Namespace = "other"
+		pr.Spec.AttributeMapping["attribute.pod"] = `assertion["kubernetes.io"]["pod"]["name"]`
+		pr.Spec.AttributeCondition = `attribute.namespace.startsWith("team-") && attribute.pod.startsWith("app-")`
+		b, err := Get(pr.Spec.Target)
+		Expect(err).NotTo(HaveOccurred())
+		err = b.(ClaimChecker).CheckClaims(wi, pr, "default")
+		Expect(err).To(HaveOccurred())
+		Expect(err).NotTo(MatchError(ErrClaimsUnknown))
+	})
+
+	It("Should report the claims of other credential sources as unknown", func() {
+		b, err := Get(pr.Spec.Target)
+		Expect(err).NotTo(HaveOccurred())
+		wi.Spec.CredentialSource = &k8sv1alpha1.CredentialSource{
+			Type:   k8sv1alpha1.CredentialSourceTypeSPIFFE,
+			SPIFFE: &k8sv1alpha1.SPIFFECredentialSource{},
+		}
+		Expect(b.(ClaimChecker).CheckClaims(wi, pr, "default")).To(MatchError(ErrClaimsUnknown))
+
+		wi.Spec.CredentialSource = &k8sv1alpha1.CredentialSource{
+			Type: k8sv1alpha1.CredentialSourceTypeX509,
+			X509: &k8sv1alpha1.X509CredentialSource{},
+		}
+		Expect(b.(ClaimChecker).CheckClaims(wi, pr, "default")).To(MatchError(ErrClaimsUnknown))
+	})
+
+	It("Should report the principal mapped from the claims", func() {
+		pr.Spec.AttributeMapping["google.subject"] = `assertion["kubernetes.io"]["namespace"] + "/" + assertion["kubernetes.io"]["serviceaccount"]["name"]`
+		b, err := Get(pr.Spec.Target)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(b.(PrincipalReporter).Principal(wi, pr, "app-sa")).To(Equal(
+			"principal://iam.googleapis.com/projects/123456/locations/global/workloadIdentityPools/pool/subject/team-a/app-sa"))
+	})
+})
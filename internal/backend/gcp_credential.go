@@ -0,0 +1,291 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	k8sv1alpha1 "github.com/piny940/kwimount/api/v1alpha1"
+)
+
+const (
+	GCP_EXTERNAL_ACCOUNT_TYPE              = "external_account"
+	GCP_IMPERSONATED_SERVICE_ACCOUNT_TYPE  = "impersonated_service_account"
+	GCP_UNIVERSE_DOMAIN                    = "googleapis.com"
+	GCP_AUDIENCE_BASE                      = "//iam.%s/projects/%s/locations/%s/workloadIdentityPools/%s/providers/%s"
+	GCP_SUBJECT_TOKEN_TYPE_JWT             = "urn:ietf:params:oauth:token-type:jwt"
+	GCP_SUBJECT_TOKEN_TYPE_MTLS            = "urn:ietf:params:oauth:token-type:mtls"
+	GCP_TOKEN_URL_BASE                     = "https://sts.%s/v1/token"
+	GCP_MTLS_TOKEN_URL_BASE                = "https://sts.mtls.%s/v1/token"
+	GCP_CERTIFICATE_CONFIG_FILE_NAME       = "certificate_config.json"
+	GCP_IMPERSONATION_HOST_BASE            = "iamcredentials.%s"
+	GCP_SERVICE_ACCOUNT_IMPERSONATION_BASE = "https://%s/v1/projects/-/serviceAccounts/%s:generateAccessToken"
+	GCP_DELEGATE_BASE                      = "projects/-/serviceAccounts/%s"
+	GCP_CREDENTIAL_SOURCE_FORMAT_TEXT      = "text"
+	GCP_CREDENTIAL_SOURCE_FORMAT_JSON      = "json"
+	GCP_EXTERNAL_ACCOUNT_AUDIENCE_PREFIX   = "//iam.%s/"
+)
+
+// GCPCredentialConfig is a credential configuration file accepted by GOOGLE_APPLICATION_CREDENTIALS.
+type GCPCredentialConfig interface {
+	Validate() error
+}
+
+var _ GCPCredentialConfig = &GCPExternalAccount{}
+var _ GCPCredentialConfig = &GCPImpersonatedServiceAccount{}
+
+// GCPExternalAccount is the external_account credential configuration read by the Google client libraries.
+// See https://google.aip.dev/auth/4117 for the schema.
+type GCPExternalAccount struct {
+	UniverseDomain                 string                          `json:"universe_domain,omitempty"`
+	Type                           string                          `json:"type"`
+	Audience                       string                          `json:"audience"`
+	SubjectTokenType               string                          `json:"subject_token_type"`
+	TokenURL                       string                          `json:"token_url"`
+	CredentialSource               GCPCredentialSource             `json:"credential_source"`
+	ServiceAccountImpersonationURL string                          `json:"service_account_impersonation_url,omitempty"`
+	ServiceAccountImpersonation    *GCPServiceAccountImpersonation `json:"service_account_impersonation,omitempty"`
+	QuotaProjectID                 string                          `json:"quota_project_id,omitempty"`
+}
+
+// GCPCredentialSource is either a file containing the subject token or a client certificate.
+type GCPCredentialSource struct {
+	File        string                          `json:"file,omitempty"`
+	Format      *GCPCredentialSourceFormat      `json:"format,omitempty"`
+	Certificate *GCPCredentialSourceCertificate `json:"certificate,omitempty"`
+}
+
+type GCPCredentialSourceFormat struct {
+	Type                  string `json:"type"`
+	SubjectTokenFieldName string `json:"subject_token_field_name,omitempty"`
+}
+
+type GCPCredentialSourceCertificate struct {
+	CertificateConfigLocation   string `json:"certificate_config_location,omitempty"`
+	UseDefaultCertificateConfig bool   `json:"use_default_certificate_config,omitempty"`
+	TrustChainPath              string `json:"trust_chain_path,omitempty"`
+}
+
+// GCPCertificateConfig is the certificate configuration referenced by certificate_config_location.
+type GCPCertificateConfig struct {
+	CertConfigs GCPCertConfigs `json:"cert_configs"`
+}
+
+type GCPCertConfigs struct {
+	Workload *GCPWorkloadCertConfig `json:"workload,omitempty"`
+}
+
+type GCPWorkloadCertConfig struct {
+	CertPath string `json:"cert_path"`
+	KeyPath  string `json:"key_path"`
+}
+
+type GCPServiceAccountImpersonation struct {
+	TokenLifetimeSeconds int32 `json:"token_lifetime_seconds,omitempty"`
+}
+
+// GCPImpersonatedServiceAccount impersonates a service account through a chain of delegates,
+// which external_account credentials cannot express.
+type GCPImpersonatedServiceAccount struct {
+	Type                           string              `json:"type"`
+	ServiceAccountImpersonationURL string              `json:"service_account_impersonation_url"`
+	Delegates                      []string            `json:"delegates,omitempty"`
+	SourceCredentials              *GCPExternalAccount `json:"source_credentials"`
+	QuotaProjectID                 string              `json:"quota_project_id,omitempty"`
+}
+
+// Validate checks the configuration against the external_account schema.
+func (c *GCPExternalAccount) Validate() error {
+	if c.Type != GCP_EXTERNAL_ACCOUNT_TYPE {
+		return fmt.Errorf("type must be %s: %q", GCP_EXTERNAL_ACCOUNT_TYPE, c.Type)
+	}
+	universeDomain := c.UniverseDomain
+	if universeDomain == "" {
+		universeDomain = GCP_UNIVERSE_DOMAIN
+	}
+	if prefix := fmt.Sprintf(GCP_EXTERNAL_ACCOUNT_AUDIENCE_PREFIX, universeDomain); !strings.HasPrefix(c.Audience, prefix) {
+		return fmt.Errorf("audience must start with %s: %q", prefix, c.Audience)
+	}
+	if err := validateHTTPSURL("token_url", c.TokenURL); err != nil {
+		return err
+	}
+	switch {
+	case c.CredentialSource.File != "" && c.CredentialSource.Certificate == nil:
+		if c.SubjectTokenType != GCP_SUBJECT_TOKEN_TYPE_JWT {
+			return fmt.Errorf("unsupported subject_token_type for a file credential source: %q", c.SubjectTokenType)
+		}
+	case c.CredentialSource.File == "" && c.CredentialSource.Certificate != nil:
+		if c.SubjectTokenType != GCP_SUBJECT_TOKEN_TYPE_MTLS {
+			return fmt.Errorf("unsupported subject_token_type for a certificate credential source: %q", c.SubjectTokenType)
+		}
+		if c.CredentialSource.Format != nil {
+			return errors.New("credential_source.format cannot be set for a certificate credential source")
+		}
+		cert := c.CredentialSource.Certificate
+		if (cert.CertificateConfigLocation == "") == !cert.UseDefaultCertificateConfig {
+			return errors.New("exactly one of credential_source.certificate.certificate_config_location and use_default_certificate_config must be set")
+		}
+	default:
+		return errors.New("exactly one of credential_source.file and credential_source.certificate must be set")
+	}
+	if f := c.CredentialSource.Format; f != nil {
+		switch f.Type {
+		case GCP_CREDENTIAL_SOURCE_FORMAT_TEXT:
+		case GCP_CREDENTIAL_SOURCE_FORMAT_JSON:
+			if f.SubjectTokenFieldName == "" {
+				return errors.New("credential_source.format.subject_token_field_name is required for the json format")
+			}
+		default:
+			return fmt.Errorf("unsupported credential_source.format.type: %q", f.Type)
+		}
+	}
+	if c.ServiceAccountImpersonationURL != "" {
+		if err := validateHTTPSURL("service_account_impersonation_url", c.ServiceAccountImpersonationURL); err != nil {
+			return err
+		}
+	} else if c.ServiceAccountImpersonation != nil {
+		return errors.New("service_account_impersonation requires service_account_impersonation_url")
+	}
+	if i := c.ServiceAccountImpersonation; i != nil && i.TokenLifetimeSeconds != 0 &&
+		(i.TokenLifetimeSeconds < k8sv1alpha1.GCP_MIN_TOKEN_LIFETIME_SECONDS || i.TokenLifetimeSeconds > k8sv1alpha1.GCP_MAX_TOKEN_LIFETIME_SECONDS) {
+		return fmt.Errorf("service_account_impersonation.token_lifetime_seconds is out of range: %d", i.TokenLifetimeSeconds)
+	}
+	return nil
+}
+
+// Validate checks the configuration and its source credentials.
+func (c *GCPImpersonatedServiceAccount) Validate() error {
+	if c.Type != GCP_IMPERSONATED_SERVICE_ACCOUNT_TYPE {
+		return fmt.Errorf("type must be %s: %q", GCP_IMPERSONATED_SERVICE_ACCOUNT_TYPE, c.Type)
+	}
+	if err := validateHTTPSURL("service_account_impersonation_url", c.ServiceAccountImpersonationURL); err != nil {
+		return err
+	}
+	if c.SourceCredentials == nil {
+		return errors.New("source_credentials cannot be empty")
+	}
+	if c.SourceCredentials.ServiceAccountImpersonationURL != "" {
+		return errors.New("source_credentials cannot impersonate a service account")
+	}
+	return c.SourceCredentials.Validate()
+}
+
+func validateHTTPSURL(name, value string) error {
+	u, err := url.Parse(value)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("%s must be an https URL: %q", name, value)
+	}
+	return nil
+}
+
+// gcpCredentialConfig builds the credential configuration of the WorkloadIdentity.
+func gcpCredentialConfig(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider) GCPCredentialConfig {
+	universeDomain := gcpUniverseDomain(pr)
+	external := &GCPExternalAccount{
+		UniverseDomain:   universeDomain,
+		Type:             GCP_EXTERNAL_ACCOUNT_TYPE,
+		Audience:         fmt.Sprintf(GCP_AUDIENCE_BASE, universeDomain, pr.Spec.Project.Number, pr.Spec.Location, pr.Spec.PoolID, pr.Spec.ProviderID),
+		SubjectTokenType: GCP_SUBJECT_TOKEN_TYPE_JWT,
+		TokenURL:         fmt.Sprintf(GCP_TOKEN_URL_BASE, universeDomain),
+		CredentialSource: GCPCredentialSource{
+			File:   GCP_TOKEN_MOUNT_PATH + GCP_TOKEN_PATH,
+			Format: &GCPCredentialSourceFormat{Type: GCP_CREDENTIAL_SOURCE_FORMAT_TEXT},
+		},
+	}
+	if EffectiveCredentialSource(wi, pr).Type == k8sv1alpha1.CredentialSourceTypeX509 {
+		external.SubjectTokenType = GCP_SUBJECT_TOKEN_TYPE_MTLS
+		external.TokenURL = fmt.Sprintf(GCP_MTLS_TOKEN_URL_BASE, universeDomain)
+		external.CredentialSource = GCPCredentialSource{
+			Certificate: &GCPCredentialSourceCertificate{
+				CertificateConfigLocation: GCP_CONFIGURATION_MOUNT_PATH + GCP_CERTIFICATE_CONFIG_FILE_NAME,
+			},
+		}
+	}
+	if pr.Spec.TokenURL != "" {
+		external.TokenURL = pr.Spec.TokenURL
+	}
+	if wi.Spec.GCP != nil {
+		external.QuotaProjectID = wi.Spec.GCP.QuotaProjectID
+	}
+	if gcpAccessMode(wi) == k8sv1alpha1.GCPAccessModeDirect {
+		return external
+	}
+	impersonationHost := pr.Spec.ImpersonationHost
+	if impersonationHost == "" {
+		impersonationHost = fmt.Sprintf(GCP_IMPERSONATION_HOST_BASE, universeDomain)
+	}
+	impersonationURL := fmt.Sprintf(GCP_SERVICE_ACCOUNT_IMPERSONATION_BASE, impersonationHost, url.PathEscape(wi.Spec.TargetServiceAccount))
+	if wi.Spec.GCP != nil && len(wi.Spec.GCP.Delegates) > 0 {
+		delegates := make([]string, 0, len(wi.Spec.GCP.Delegates))
+		for _, delegate := range wi.Spec.GCP.Delegates {
+			delegates = append(delegates, fmt.Sprintf(GCP_DELEGATE_BASE, delegate))
+		}
+		return &GCPImpersonatedServiceAccount{
+			Type:                           GCP_IMPERSONATED_SERVICE_ACCOUNT_TYPE,
+			ServiceAccountImpersonationURL: impersonationURL,
+			Delegates:                      delegates,
+			SourceCredentials:              external,
+			QuotaProjectID:                 wi.Spec.GCP.QuotaProjectID,
+		}
+	}
+	external.ServiceAccountImpersonationURL = impersonationURL
+	if wi.Spec.GCP != nil && wi.Spec.GCP.TokenLifetimeSeconds != nil {
+		external.ServiceAccountImpersonation = &GCPServiceAccountImpersonation{
+			TokenLifetimeSeconds: *wi.Spec.GCP.TokenLifetimeSeconds,
+		}
+	}
+	return external
+}
+
+func gcpUniverseDomain(pr *k8sv1alpha1.Provider) string {
+	if pr.Spec.UniverseDomain == "" {
+		return GCP_UNIVERSE_DOMAIN
+	}
+	return pr.Spec.UniverseDomain
+}
+
+// gcpCertificateConfig returns the certificate configuration pointing at the mounted client certificate.
+func gcpCertificateConfig() *GCPCertificateConfig {
+	return &GCPCertificateConfig{
+		CertConfigs: GCPCertConfigs{
+			Workload: &GCPWorkloadCertConfig{
+				CertPath: X509_MOUNT_PATH + X509_CERT_PATH,
+				KeyPath:  X509_MOUNT_PATH + X509_KEY_PATH,
+			},
+		},
+	}
+}
+
+func marshalGCPCredentialConfig(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider) (string, error) {
+	conf := gcpCredentialConfig(wi, pr)
+	if err := conf.Validate(); err != nil {
+		return "", fmt.Errorf("invalid gcp credential configuration: %w", err)
+	}
+	return marshalIndent(conf)
+}
+
+func marshalIndent(v any) (string, error) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
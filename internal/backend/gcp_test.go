@@ -0,0 +1,246 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+
+	k8sv1alpha1 "github.com/piny940/kwimount/api/v1alpha1"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata")
+
+var _ = Describe("GCP Backend", func() {
+	pr := &k8sv1alpha1.Provider{
+		Spec: k8sv1alpha1.ProviderSpec{
+			Target:     k8sv1alpha1.ProviderTargetTypeGCP,
+			PoolID:     "pool",
+			ProviderID: "provider",
+			Location:   "global",
+			Project:    k8sv1alpha1.Project{Name: "project", Number: "123456"},
+		},
+	}
+	newWorkloadIdentity := func(mode k8sv1alpha1.GCPAccessMode, sa string) *k8sv1alpha1.WorkloadIdentity {
+		return &k8sv1alpha1.WorkloadIdentity{
+			ObjectMeta: metav1.ObjectMeta{Name: "wi", Namespace: "default"},
+			Spec: k8sv1alpha1.WorkloadIdentitySpec{
+				Deployment:           "app",
+				TargetServiceAccount: sa,
+				GCP:                  &k8sv1alpha1.WorkloadIdentityGCP{AccessMode: mode},
+			},
+		}
+	}
+
+	It("Should require a target service account for impersonation", func() {
+		b, err := Get(pr.Spec.Target)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(b.ValidateWorkloadIdentity(newWorkloadIdentity(k8sv1alpha1.GCPAccessModeImpersonation, ""), pr)).NotTo(Succeed())
+		Expect(b.ValidateWorkloadIdentity(newWorkloadIdentity(k8sv1alpha1.GCPAccessModeDirect, ""), pr)).To(Succeed())
+	})
+
+	It("Should report the principal in the direct mode", func() {
+		b, err := Get(pr.Spec.Target)
+		Expect(err).NotTo(HaveOccurred())
+		reporter, ok := b.(PrincipalReporter)
+		Expect(ok).To(BeTrue())
+		Expect(reporter.Principal(newWorkloadIdentity(k8sv1alpha1.GCPAccessModeDirect, ""), pr, "app-sa")).To(Equal(
+			"principal://iam.googleapis.com/projects/123456/locations/global/workloadIdentityPools/pool/subject/system:serviceaccount:default:app-sa"))
+		Expect(reporter.Principal(newWorkloadIdentity(k8sv1alpha1.GCPAccessModeImpersonation, "sa@project.iam.gserviceaccount.com"), pr, "app-sa")).To(BeEmpty())
+	})
+
+	DescribeTable("Should render the credential configuration",
+		func(golden string, mutate func(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider)) {
+			wi := newWorkloadIdentity(k8sv1alpha1.GCPAccessModeImpersonation, "sa@project.iam.gserviceaccount.com")
+			p := pr.DeepCopy()
+			mutate(wi, p)
+			b, err := Get(p.Spec.Target)
+			Expect(err).NotTo(HaveOccurred())
+			data, err := b.ConfigData(wi, p)
+			Expect(err).NotTo(HaveOccurred())
+			conf := data[GCP_CONFIGURATION_FILE_NAME]
+			Expect(json.Valid([]byte(conf))).To(BeTrue())
+
+			path := filepath.Join("testdata", "gcp", golden)
+			if *updateGolden {
+				Expect(os.WriteFile(path, []byte(conf+"\n"), 0o644)).To(Succeed())
+			}
+			expected, err := os.ReadFile(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(conf + "\n").To(Equal(string(expected)))
+		},
+		Entry("impersonation", "impersonation.json", func(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider) {}),
+		Entry("direct access", "direct.json", func(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider) {
+			wi.Spec.GCP.AccessMode = k8sv1alpha1.GCPAccessModeDirect
+			wi.Spec.TargetServiceAccount = ""
+		}),
+		Entry("token lifetime", "token-lifetime.json", func(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider) {
+			wi.Spec.GCP.TokenLifetimeSeconds = ptr.To[int32](7200)
+		}),
+		Entry("delegates", "delegates.json", func(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider) {
+			wi.Spec.GCP.Delegates = []string{"first@project.iam.gserviceaccount.com", "second@project.iam.gserviceaccount.com"}
+		}),
+		Entry("x509 certificate", "x509.json", func(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider) {
+			pr.Spec.CredentialSource = &k8sv1alpha1.CredentialSource{
+				Type: k8sv1alpha1.CredentialSourceTypeX509,
+				X509: &k8sv1alpha1.X509CredentialSource{SecretName: "cert"},
+			}
+		}),
+		Entry("custom endpoints", "endpoints.json", func(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider) {
+			pr.Spec.UniverseDomain = "example-universe.com"
+			pr.Spec.TokenURL = "https://sts-psc.p.googleapis.com/v1/token"
+			pr.Spec.ImpersonationHost = "iamcredentials-psc.p.googleapis.com"
+		}),
+		Entry("quota project", "quota-project.json", func(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider) {
+			wi.Spec.GCP.QuotaProjectID = "billing-project"
+		}),
+		Entry("special characters", "escaped.json", func(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider) {
+			wi.Spec.TargetServiceAccount = `sa"/@project.iam.gserviceaccount.com`
+			pr.Spec.PoolID = `pool", "type": "x`
+		}),
+	)
+
+	It("Should reject an invalid credential configuration", func() {
+		conf := gcpCredentialConfig(newWorkloadIdentity(k8sv1alpha1.GCPAccessModeDirect, ""), pr).(*GCPExternalAccount)
+		Expect(conf.Validate()).To(Succeed())
+
+		conf.TokenURL = "http://sts.googleapis.com/v1/token"
+		Expect(conf.Validate()).NotTo(Succeed())
+		conf.TokenURL = "https://sts.googleapis.com/v1/token"
+
+		conf.ServiceAccountImpersonation = &GCPServiceAccountImpersonation{TokenLifetimeSeconds: 3600}
+		Expect(conf.Validate()).NotTo(Succeed())
+		conf.ServiceAccountImpersonationURL = "https://iamcredentials.googleapis.com/v1/projects/-/serviceAccounts/sa@project.iam.gserviceaccount.com:generateAccessToken"
+		Expect(conf.Validate()).To(Succeed())
+
+		Expect((&GCPImpersonatedServiceAccount{
+			Type:                           GCP_IMPERSONATED_SERVICE_ACCOUNT_TYPE,
+			ServiceAccountImpersonationURL: conf.ServiceAccountImpersonationURL,
+			SourceCredentials:              conf,
+		}).Validate()).NotTo(Succeed())
+	})
+
+	It("Should validate the endpoints", func() {
+		b, err := Get(pr.Spec.Target)
+		Expect(err).NotTo(HaveOccurred())
+		spec := pr.Spec.DeepCopy()
+		spec.UniverseDomain = "example-universe.com"
+		spec.TokenURL = "https://10.0.0.1/v1/token"
+		spec.ImpersonationHost = "iamcredentials-psc.p.googleapis.com:443"
+		Expect(b.Validate(spec)).To(Succeed())
+		Expect(b.Audience(&k8sv1alpha1.Provider{Spec: *spec})).To(HavePrefix("https://iam.example-universe.com/"))
+
+		invalid := spec.DeepCopy()
+		invalid.UniverseDomain = "https://example-universe.com"
+		Expect(b.Validate(invalid)).NotTo(Succeed())
+		invalid = spec.DeepCopy()
+		invalid.TokenURL = "http://sts.googleapis.com/v1/token"
+		Expect(b.Validate(invalid)).NotTo(Succeed())
+		invalid = spec.DeepCopy()
+		invalid.ImpersonationHost = "iamcredentials.googleapis.com/v1"
+		Expect(b.Validate(invalid)).NotTo(Succeed())
+	})
+
+	It("Should inject the project only when requested", func() {
+		b, err := Get(pr.Spec.Target)
+		Expect(err).NotTo(HaveOccurred())
+		wi := newWorkloadIdentity(k8sv1alpha1.GCPAccessModeImpersonation, "sa@project.iam.gserviceaccount.com")
+		Expect(envMap(b.Workload(wi, pr, "conf"))).NotTo(HaveKey(GOOGLE_CLOUD_PROJECT_ENV))
+
+		wi.Spec.GCP.InjectProjectEnv = true
+		env := envMap(b.Workload(wi, pr, "conf"))
+		Expect(env).To(HaveKeyWithValue(GOOGLE_CLOUD_PROJECT_ENV, "project"))
+		Expect(env).To(HaveKeyWithValue(CLOUDSDK_CORE_PROJECT_ENV, "project"))
+	})
+
+	It("Should render the gcloud configuration", func() {
+		b, err := Get(pr.Spec.Target)
+		Expect(err).NotTo(HaveOccurred())
+		wi := newWorkloadIdentity(k8sv1alpha1.GCPAccessModeImpersonation, "sa@project.iam.gserviceaccount.com")
+		data, w, err := Render(b, wi, pr, "conf")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data).NotTo(HaveKey(GCLOUD_CONFIGURATION_FILE_NAME))
+		Expect(findVolume(w, GCLOUD_CONFIG_VOLUME_NAME)).To(BeNil())
+
+		wi.Spec.GCP.InjectGcloudConfig = true
+		wi.Spec.GCP.InjectProjectEnv = true
+		wi.Spec.GCP.QuotaProjectID = "billing-project"
+		data, w, err = Render(b, wi, pr, "conf")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data).To(HaveKeyWithValue(GCLOUD_ACTIVE_CONFIG_FILE_NAME, "kwimount"))
+		Expect(data[GCLOUD_CONFIGURATION_FILE_NAME]).To(Equal(`[auth]
+credential_file_override = /etc/kwimount-gcp-workload-identity/gcp-credential-configuration.json
+
+[core]
+universe_domain = googleapis.com
+project = project
+
+[billing]
+quota_project = billing-project
+`))
+		Expect(envMap(w)).To(HaveKeyWithValue(CLOUDSDK_CONFIG_ENV, GCLOUD_CONFIG_MOUNT_PATH))
+		Expect(envMap(w)).To(HaveKeyWithValue(CLOUDSDK_CREDENTIAL_FILE_ENV, GCP_CONFIGURATION_MOUNT_PATH+GCP_CONFIGURATION_FILE_NAME))
+		Expect(findVolume(w, GCLOUD_CONFIG_VOLUME_NAME).EmptyDir).NotTo(BeNil())
+		subPaths := map[string]string{}
+		for _, m := range w.VolumeMounts {
+			if m.SubPath != nil {
+				subPaths[*m.MountPath] = *m.SubPath
+			}
+		}
+		Expect(subPaths).To(Equal(map[string]string{
+			GCLOUD_CONFIG_MOUNT_PATH + "active_config":                  GCLOUD_ACTIVE_CONFIG_FILE_NAME,
+			GCLOUD_CONFIG_MOUNT_PATH + "configurations/config_kwimount": GCLOUD_CONFIGURATION_FILE_NAME,
+		}))
+	})
+
+	It("Should use the pool provider as both audiences by default", func() {
+		b, err := Get(pr.Spec.Target)
+		Expect(err).NotTo(HaveOccurred())
+		wi := newWorkloadIdentity(k8sv1alpha1.GCPAccessModeImpersonation, "sa@project.iam.gserviceaccount.com")
+		data, w, err := Render(b, wi, pr, "conf")
+		Expect(err).NotTo(HaveOccurred())
+		token := findVolume(w, GCP_TOKEN_VOLUME_NAME)
+		Expect(*token.Projected.Sources[0].ServiceAccountToken.Audience).To(Equal("https://iam.googleapis.com/projects/123456/locations/global/workloadIdentityPools/pool/providers/provider"))
+
+		conf := map[string]any{}
+		Expect(json.Unmarshal([]byte(data[GCP_CONFIGURATION_FILE_NAME]), &conf)).To(Succeed())
+		Expect(conf["audience"]).To(Equal("//iam.googleapis.com/projects/123456/locations/global/workloadIdentityPools/pool/providers/provider"))
+	})
+
+	It("Should project the custom audience", func() {
+		p := pr.DeepCopy()
+		p.Spec.Audience = "https://shared-pool.example.com"
+		b, err := Get(p.Spec.Target)
+		Expect(err).NotTo(HaveOccurred())
+		wi := newWorkloadIdentity(k8sv1alpha1.GCPAccessModeImpersonation, "sa@project.iam.gserviceaccount.com")
+		data, w, err := Render(b, wi, p, "conf")
+		Expect(err).NotTo(HaveOccurred())
+		token := findVolume(w, GCP_TOKEN_VOLUME_NAME)
+		Expect(*token.Projected.Sources[0].ServiceAccountToken.Audience).To(Equal("https://shared-pool.example.com"))
+
+		conf := map[string]any{}
+		Expect(json.Unmarshal([]byte(data[GCP_CONFIGURATION_FILE_NAME]), &conf)).To(Succeed())
+		Expect(conf["audience"]).To(Equal("//iam.googleapis.com/projects/123456/locations/global/workloadIdentityPools/pool/providers/provider"))
+	})
+})
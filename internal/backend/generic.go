@@ -0,0 +1,120 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+
+	k8sv1alpha1 "github.com/piny940/kwimount/api/v1alpha1"
+)
+
+const (
+	GENERIC_TOKEN_VOLUME_NAME     = "kwimount-token"
+	GENERIC_MIN_EXPIRATION_SECOND = 600
+)
+
+type generic struct{}
+
+var _ Backend = &generic{}
+
+func init() {
+	Register(k8sv1alpha1.ProviderTargetTypeGeneric, &generic{})
+}
+
+func (g *generic) Validate(spec *k8sv1alpha1.ProviderSpec) error {
+	p := field.NewPath("spec", "generic")
+	if spec.Generic == nil {
+		return field.Required(p, "generic cannot be empty when target is generic")
+	}
+	if len(spec.Generic.Tokens) == 0 {
+		return field.Required(p.Child("tokens"), "tokens cannot be empty")
+	}
+	if spec.Generic.ExpirationSeconds != 0 && spec.Generic.ExpirationSeconds < GENERIC_MIN_EXPIRATION_SECOND {
+		return field.Invalid(p.Child("expirationSeconds"), spec.Generic.ExpirationSeconds, fmt.Sprintf("expirationSeconds must be at least %d", GENERIC_MIN_EXPIRATION_SECOND))
+	}
+	if spec.Generic.MountPath != "" && !path.IsAbs(spec.Generic.MountPath) {
+		return field.Invalid(p.Child("mountPath"), spec.Generic.MountPath, "mountPath must be an absolute path")
+	}
+	paths := map[string]bool{}
+	for i, token := range spec.Generic.Tokens {
+		tp := p.Child("tokens").Index(i)
+		if token.Audience == "" {
+			return field.Invalid(tp.Child("audience"), token.Audience, "audience cannot be empty")
+		}
+		if token.Path == "" || path.IsAbs(token.Path) || strings.Contains(token.Path, "..") {
+			return field.Invalid(tp.Child("path"), token.Path, "path must be a relative path inside mountPath")
+		}
+		if paths[token.Path] {
+			return field.Duplicate(tp.Child("path"), token.Path)
+		}
+		paths[token.Path] = true
+		if token.EnvName != "" {
+			if errs := validation.IsEnvVarName(token.EnvName); len(errs) > 0 {
+				return field.Invalid(tp.Child("envName"), token.EnvName, strings.Join(errs, ", "))
+			}
+		}
+	}
+	return nil
+}
+
+func (g *generic) ValidateWorkloadIdentity(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider) error {
+	if pr.Spec.Generic == nil || len(pr.Spec.Generic.Tokens) == 0 {
+		return fmt.Errorf("generic tokens are required for provider target %s", pr.Spec.Target)
+	}
+	return nil
+}
+
+// Audience returns the audience of the first token.
+func (g *generic) Audience(pr *k8sv1alpha1.Provider) string {
+	return pr.Spec.Generic.Tokens[0].Audience
+}
+
+// ConfigData returns no files because the generic target only projects tokens.
+func (g *generic) ConfigData(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider) (map[string]string, error) {
+	return map[string]string{}, nil
+}
+
+func (g *generic) Workload(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider, configMapName string) *Workload {
+	mountPath := pr.Spec.Generic.MountPath
+	if !strings.HasSuffix(mountPath, "/") {
+		mountPath += "/"
+	}
+	env := []*corev1apply.EnvVarApplyConfiguration{}
+	token := &Token{
+		VolumeName:        GENERIC_TOKEN_VOLUME_NAME,
+		ExpirationSeconds: pr.Spec.Generic.ExpirationSeconds,
+	}
+	for _, t := range pr.Spec.Generic.Tokens {
+		if t.EnvName != "" {
+			env = append(env, Env(t.EnvName, mountPath+t.Path))
+		}
+		token.Projections = append(token.Projections, TokenProjection{Audience: t.Audience, Path: t.Path})
+	}
+	return &Workload{
+		Env: env,
+		VolumeMounts: []*corev1apply.VolumeMountApplyConfiguration{
+			ReadOnlyMount(GENERIC_TOKEN_VOLUME_NAME, mountPath),
+		},
+		Token: token,
+	}
+}
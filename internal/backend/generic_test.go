@@ -0,0 +1,78 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	k8sv1alpha1 "github.com/piny940/kwimount/api/v1alpha1"
+)
+
+var _ = Describe("Generic Backend", func() {
+	newProvider := func() *k8sv1alpha1.Provider {
+		return &k8sv1alpha1.Provider{
+			Spec: k8sv1alpha1.ProviderSpec{
+				Target: k8sv1alpha1.ProviderTargetTypeGeneric,
+				Generic: &k8sv1alpha1.GenericProvider{
+					ExpirationSeconds: 7200,
+					MountPath:         "/var/run/secrets/tokens",
+					Tokens: []k8sv1alpha1.GenericToken{
+						{Audience: "kafka", Path: "kafka-token", EnvName: "KAFKA_TOKEN_FILE"},
+						{Audience: "https://sts.example.com", Path: "sts-token"},
+					},
+				},
+			},
+		}
+	}
+
+	It("Should only project the tokens", func() {
+		pr := newProvider()
+		b, err := Get(pr.Spec.Target)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(b.Validate(&pr.Spec)).To(Succeed())
+
+		data, w, err := Render(b, &k8sv1alpha1.WorkloadIdentity{}, pr, "conf")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data).To(BeEmpty())
+		Expect(envMap(w)).To(Equal(map[string]string{
+			"KAFKA_TOKEN_FILE": "/var/run/secrets/tokens/kafka-token",
+		}))
+		Expect(w.Volumes).To(HaveLen(1))
+		sources := w.Volumes[0].Projected.Sources
+		Expect(sources).To(HaveLen(2))
+		Expect(*sources[1].ServiceAccountToken.Audience).To(Equal("https://sts.example.com"))
+		Expect(*sources[1].ServiceAccountToken.ExpirationSeconds).To(Equal(int64(7200)))
+		Expect(*w.VolumeMounts[0].MountPath).To(Equal("/var/run/secrets/tokens/"))
+	})
+
+	DescribeTable("Should reject an invalid spec",
+		func(mutate func(spec *k8sv1alpha1.GenericProvider)) {
+			pr := newProvider()
+			mutate(pr.Spec.Generic)
+			b, err := Get(pr.Spec.Target)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(b.Validate(&pr.Spec)).NotTo(Succeed())
+		},
+		Entry("Empty Tokens", func(spec *k8sv1alpha1.GenericProvider) { spec.Tokens = nil }),
+		Entry("Short Expiration", func(spec *k8sv1alpha1.GenericProvider) { spec.ExpirationSeconds = 60 }),
+		Entry("Relative MountPath", func(spec *k8sv1alpha1.GenericProvider) { spec.MountPath = "tokens" }),
+		Entry("Duplicate Path", func(spec *k8sv1alpha1.GenericProvider) { spec.Tokens[1].Path = "kafka-token" }),
+		Entry("Escaping Path", func(spec *k8sv1alpha1.GenericProvider) { spec.Tokens[0].Path = "../token" }),
+		Entry("Invalid EnvName", func(spec *k8sv1alpha1.GenericProvider) { spec.Tokens[0].EnvName = "1TOKEN" }),
+	)
+})
@@ -0,0 +1,84 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"fmt"
+
+	k8sv1alpha1 "github.com/piny940/kwimount/api/v1alpha1"
+)
+
+const (
+	GKE_SERVICE_ACCOUNT_ANNOTATION = "iam.gke.io/gcp-service-account"
+	GKE_PRINCIPAL_BASE             = "principal://iam.googleapis.com/projects/%s/locations/global/workloadIdentityPools/%s.svc.id.goog/subject/ns/%s/sa/%s"
+)
+
+// gkeNative delegates to the Workload Identity Federation for GKE. Nothing is
+// mounted; the GKE metadata server serves the credentials of the annotated
+// Kubernetes service account.
+type gkeNative struct{}
+
+var _ Backend = &gkeNative{}
+var _ PrincipalReporter = &gkeNative{}
+
+func init() {
+	Register(k8sv1alpha1.ProviderTargetTypeGKENative, &gkeNative{})
+}
+
+func (g *gkeNative) Validate(spec *k8sv1alpha1.ProviderSpec) error {
+	return nil
+}
+
+func (g *gkeNative) ValidateWorkloadIdentity(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider) error {
+	if gcpAccessMode(wi) == k8sv1alpha1.GCPAccessModeImpersonation && wi.Spec.TargetServiceAccount == "" {
+		return fmt.Errorf("targetServiceAccount is required for provider target %s", pr.Spec.Target)
+	}
+	return nil
+}
+
+func (g *gkeNative) Audience(pr *k8sv1alpha1.Provider) string {
+	return ""
+}
+
+func (g *gkeNative) ConfigData(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider) (map[string]string, error) {
+	return map[string]string{}, nil
+}
+
+func (g *gkeNative) Workload(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider, configMapName string) *Workload {
+	w := &Workload{}
+	if gcpAccessMode(wi) == k8sv1alpha1.GCPAccessModeImpersonation {
+		w.ServiceAccountAnnotations = map[string]string{
+			GKE_SERVICE_ACCOUNT_ANNOTATION: wi.Spec.TargetServiceAccount,
+		}
+	}
+	return w
+}
+
+// Principal returns the principal of the Kubernetes service account in the
+// workload identity pool of the project, which is known only if the Provider
+// has the project.
+func (g *gkeNative) Principal(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider, serviceAccountName string) string {
+	if gcpAccessMode(wi) != k8sv1alpha1.GCPAccessModeDirect || pr.Spec.Project.Number == "" || pr.Spec.Project.Name == "" {
+		return ""
+	}
+	return fmt.Sprintf(GKE_PRINCIPAL_BASE,
+		pr.Spec.Project.Number,
+		pr.Spec.Project.Name,
+		wi.Namespace,
+		serviceAccountName,
+	)
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"encoding/pem"
+	"fmt"
+	"net/url"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	k8sv1alpha1 "github.com/piny940/kwimount/api/v1alpha1"
+)
+
+const (
+	KUBERNETES_TOKEN_MOUNT_PATH         = "/var/run/kwimount-kubernetes-service-account/"
+	KUBERNETES_TOKEN_PATH               = "token"
+	KUBERNETES_TOKEN_VOLUME_NAME        = "kwimount-kubernetes-token"
+	KUBERNETES_CONFIGURATION_MOUNT_PATH = "/etc/kwimount-kubernetes/"
+	KUBERNETES_CONFIGURATION_FILE_NAME  = "kubeconfig"
+	KUBERNETES_KUBECONFIG_NAME          = "kwimount"
+	KUBECONFIG_ENV                      = "KUBECONFIG"
+)
+
+type kubernetes struct{}
+
+var _ Backend = &kubernetes{}
+
+func init() {
+	Register(k8sv1alpha1.ProviderTargetTypeKubernetes, &kubernetes{})
+}
+
+func (k *kubernetes) Validate(spec *k8sv1alpha1.ProviderSpec) error {
+	path := field.NewPath("spec", "kubernetes")
+	if spec.Kubernetes == nil {
+		return field.Required(path, "kubernetes cannot be empty when target is kubernetes")
+	}
+	u, err := url.Parse(spec.Kubernetes.Server)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return field.Invalid(path.Child("server"), spec.Kubernetes.Server, "server must be an https URL")
+	}
+	if spec.Kubernetes.CertificateAuthority != "" {
+		if block, _ := pem.Decode([]byte(spec.Kubernetes.CertificateAuthority)); block == nil {
+			return field.Invalid(path.Child("certificateAuthority"), "<omitted>", "certificateAuthority must be PEM encoded")
+		}
+	}
+	if spec.Kubernetes.Audience == "" {
+		return field.Invalid(path.Child("audience"), spec.Kubernetes.Audience, "audience cannot be empty")
+	}
+	return nil
+}
+
+func (k *kubernetes) ValidateWorkloadIdentity(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider) error {
+	if pr.Spec.Kubernetes == nil {
+		return fmt.Errorf("kubernetes is required for provider target %s", pr.Spec.Target)
+	}
+	return nil
+}
+
+func (k *kubernetes) Audience(pr *k8sv1alpha1.Provider) string {
+	return pr.Spec.Kubernetes.Audience
+}
+
+// ConfigData renders a kubeconfig authenticating to the remote API server
+// with the projected token file.
+func (k *kubernetes) ConfigData(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider) (map[string]string, error) {
+	config := clientcmdapi.NewConfig()
+	config.Clusters[KUBERNETES_KUBECONFIG_NAME] = &clientcmdapi.Cluster{
+		Server:                   pr.Spec.Kubernetes.Server,
+		CertificateAuthorityData: []byte(pr.Spec.Kubernetes.CertificateAuthority),
+	}
+	config.AuthInfos[KUBERNETES_KUBECONFIG_NAME] = &clientcmdapi.AuthInfo{
+		TokenFile: KUBERNETES_TOKEN_MOUNT_PATH + KUBERNETES_TOKEN_PATH,
+	}
+	config.Contexts[KUBERNETES_KUBECONFIG_NAME] = &clientcmdapi.Context{
+		Cluster:   KUBERNETES_KUBECONFIG_NAME,
+		AuthInfo:  KUBERNETES_KUBECONFIG_NAME,
+		Namespace: pr.Spec.Kubernetes.Namespace,
+	}
+	config.CurrentContext = KUBERNETES_KUBECONFIG_NAME
+	kubeconfig, err := clientcmd.Write(*config)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		KUBERNETES_CONFIGURATION_FILE_NAME: string(kubeconfig),
+	}, nil
+}
+
+func (k *kubernetes) Workload(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider, configMapName string) *Workload {
+	return &Workload{
+		Env: []*corev1apply.EnvVarApplyConfiguration{
+			Env(KUBECONFIG_ENV, KUBERNETES_CONFIGURATION_MOUNT_PATH+KUBERNETES_CONFIGURATION_FILE_NAME),
+		},
+		VolumeMounts: []*corev1apply.VolumeMountApplyConfiguration{
+			ReadOnlyMount(KUBERNETES_TOKEN_VOLUME_NAME, KUBERNETES_TOKEN_MOUNT_PATH),
+			ReadOnlyMount(configMapName, KUBERNETES_CONFIGURATION_MOUNT_PATH),
+		},
+		Volumes: []*corev1apply.VolumeApplyConfiguration{
+			ConfigMapVolume(configMapName),
+		},
+		Token: SingleToken(KUBERNETES_TOKEN_VOLUME_NAME, k.Audience(pr), KUBERNETES_TOKEN_PATH),
+	}
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/client-go/tools/clientcmd"
+
+	k8sv1alpha1 "github.com/piny940/kwimount/api/v1alpha1"
+)
+
+const testCA = `-----BEGIN CERTIFICATE-----
+MIIBdzCCAR2gAwIBAgIBADAKBggqhkjOPQQDAjAjMSEwHwYDVQQDDBhrM3Mtc2Vy
+-----END CERTIFICATE-----
+`
+
+var _ = Describe("Kubernetes Backend", func() {
+	newProvider := func() *k8sv1alpha1.Provider {
+		return &k8sv1alpha1.Provider{
+			Spec: k8sv1alpha1.ProviderSpec{
+				Target: k8sv1alpha1.ProviderTargetTypeKubernetes,
+				Kubernetes: &k8sv1alpha1.KubernetesProvider{
+					Server:               "https://remote.example.com:6443",
+					CertificateAuthority: testCA,
+					Audience:             "https://remote.example.com",
+					Namespace:            "batch",
+				},
+			},
+		}
+	}
+
+	It("Should render a kubeconfig using the projected token", func() {
+		pr := newProvider()
+		b, err := Get(pr.Spec.Target)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(b.Validate(&pr.Spec)).To(Succeed())
+
+		data, w, err := Render(b, &k8sv1alpha1.WorkloadIdentity{}, pr, "conf")
+		Expect(err).NotTo(HaveOccurred())
+		config, err := clientcmd.Load([]byte(data[KUBERNETES_CONFIGURATION_FILE_NAME]))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(config.CurrentContext).To(Equal(KUBERNETES_KUBECONFIG_NAME))
+		Expect(config.Contexts[KUBERNETES_KUBECONFIG_NAME].Namespace).To(Equal("batch"))
+		Expect(config.Clusters[KUBERNETES_KUBECONFIG_NAME].Server).To(Equal("https://remote.example.com:6443"))
+		Expect(string(config.Clusters[KUBERNETES_KUBECONFIG_NAME].CertificateAuthorityData)).To(Equal(testCA))
+		Expect(config.AuthInfos[KUBERNETES_KUBECONFIG_NAME].TokenFile).To(Equal(KUBERNETES_TOKEN_MOUNT_PATH + KUBERNETES_TOKEN_PATH))
+
+		Expect(envMap(w)).To(HaveKeyWithValue(KUBECONFIG_ENV, KUBERNETES_CONFIGURATION_MOUNT_PATH+KUBERNETES_CONFIGURATION_FILE_NAME))
+		Expect(*findVolume(w, KUBERNETES_TOKEN_VOLUME_NAME).Projected.Sources[0].ServiceAccountToken.Audience).To(Equal("https://remote.example.com"))
+	})
+
+	DescribeTable("Should reject an invalid spec",
+		func(mutate func(spec *k8sv1alpha1.KubernetesProvider)) {
+			pr := newProvider()
+			mutate(pr.Spec.Kubernetes)
+			b, err := Get(pr.Spec.Target)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(b.Validate(&pr.Spec)).NotTo(Succeed())
+		},
+		Entry("Plain HTTP Server", func(spec *k8sv1alpha1.KubernetesProvider) { spec.Server = "http://remote.example.com" }),
+		Entry("Invalid CertificateAuthority", func(spec *k8sv1alpha1.KubernetesProvider) { spec.CertificateAuthority = "not a pem" }),
+		Entry("Empty Audience", func(spec *k8sv1alpha1.KubernetesProvider) { spec.Audience = "" }),
+	)
+})
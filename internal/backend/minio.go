@@ -0,0 +1,120 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"fmt"
+	"net/url"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+
+	k8sv1alpha1 "github.com/piny940/kwimount/api/v1alpha1"
+)
+
+const (
+	MINIO_TOKEN_MOUNT_PATH         = "/var/run/kwimount-minio-service-account/"
+	MINIO_TOKEN_PATH               = "token"
+	MINIO_TOKEN_VOLUME_NAME        = "kwimount-minio-token"
+	MINIO_CONFIGURATION_MOUNT_PATH = "/etc/kwimount-minio/"
+	MINIO_CONFIGURATION_FILE_NAME  = "aws-config"
+	MINIO_CONF_BASE                = `[default]
+region = %s
+role_arn = %s
+web_identity_token_file = %s
+services = kwimount-minio
+
+[services kwimount-minio]
+sts =
+  endpoint_url = %s
+s3 =
+  endpoint_url = %s
+`
+)
+
+type minio struct{}
+
+var _ Backend = &minio{}
+
+func init() {
+	Register(k8sv1alpha1.ProviderTargetTypeMinIO, &minio{})
+}
+
+func (m *minio) Validate(spec *k8sv1alpha1.ProviderSpec) error {
+	path := field.NewPath("spec", "minio")
+	if spec.MinIO == nil {
+		return field.Required(path, "minio cannot be empty when target is minio")
+	}
+	u, err := url.Parse(spec.MinIO.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return field.Invalid(path.Child("endpoint"), spec.MinIO.Endpoint, "endpoint must be an http or https URL")
+	}
+	if spec.MinIO.RoleARN == "" {
+		return field.Invalid(path.Child("roleARN"), spec.MinIO.RoleARN, "roleARN cannot be empty")
+	}
+	if err := k8sv1alpha1.ValidateSingleLine(path.Child("roleARN"), spec.MinIO.RoleARN); err != nil {
+		return err
+	}
+	if spec.MinIO.Audience == "" {
+		return field.Invalid(path.Child("audience"), spec.MinIO.Audience, "audience cannot be empty")
+	}
+	if err := k8sv1alpha1.ValidateSingleLine(path.Child("region"), spec.MinIO.Region); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m *minio) ValidateWorkloadIdentity(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider) error {
+	if pr.Spec.MinIO == nil {
+		return fmt.Errorf("minio is required for provider target %s", pr.Spec.Target)
+	}
+	return nil
+}
+
+func (m *minio) Audience(pr *k8sv1alpha1.Provider) string {
+	return pr.Spec.MinIO.Audience
+}
+
+// ConfigData renders an AWS shared config file pointing the STS and S3
+// clients of the AWS SDKs at MinIO.
+func (m *minio) ConfigData(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider) (map[string]string, error) {
+	return map[string]string{
+		MINIO_CONFIGURATION_FILE_NAME: fmt.Sprintf(MINIO_CONF_BASE,
+			pr.Spec.MinIO.Region,
+			pr.Spec.MinIO.RoleARN,
+			MINIO_TOKEN_MOUNT_PATH+MINIO_TOKEN_PATH,
+			pr.Spec.MinIO.Endpoint,
+			pr.Spec.MinIO.Endpoint,
+		)}, nil
+}
+
+func (m *minio) Workload(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider, configMapName string) *Workload {
+	return &Workload{
+		Env: []*corev1apply.EnvVarApplyConfiguration{
+			Env(AWS_CONFIG_FILE_ENV, MINIO_CONFIGURATION_MOUNT_PATH+MINIO_CONFIGURATION_FILE_NAME),
+			Env(AWS_SDK_LOAD_CONFIG_ENV, "1"),
+		},
+		VolumeMounts: []*corev1apply.VolumeMountApplyConfiguration{
+			ReadOnlyMount(MINIO_TOKEN_VOLUME_NAME, MINIO_TOKEN_MOUNT_PATH),
+			ReadOnlyMount(configMapName, MINIO_CONFIGURATION_MOUNT_PATH),
+		},
+		Volumes: []*corev1apply.VolumeApplyConfiguration{
+			ConfigMapVolume(configMapName),
+		},
+		Token: SingleToken(MINIO_TOKEN_VOLUME_NAME, m.Audience(pr), MINIO_TOKEN_PATH),
+	}
+}
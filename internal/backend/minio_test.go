@@ -0,0 +1,73 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	k8sv1alpha1 "github.com/piny940/kwimount/api/v1alpha1"
+)
+
+var _ = Describe("MinIO Backend", func() {
+	newProvider := func() *k8sv1alpha1.Provider {
+		return &k8sv1alpha1.Provider{
+			Spec: k8sv1alpha1.ProviderSpec{
+				Target: k8sv1alpha1.ProviderTargetTypeMinIO,
+				MinIO: &k8sv1alpha1.MinIOProvider{
+					Endpoint: "http://minio.minio.svc:9000",
+					RoleARN:  "arn:minio:iam:::role/kwimount",
+					Audience: "minio",
+					Region:   "us-east-1",
+				},
+			},
+		}
+	}
+
+	It("Should render an AWS shared config file for MinIO", func() {
+		pr := newProvider()
+		b, err := Get(pr.Spec.Target)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(b.Validate(&pr.Spec)).To(Succeed())
+
+		data, w, err := Render(b, &k8sv1alpha1.WorkloadIdentity{}, pr, "conf")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data[MINIO_CONFIGURATION_FILE_NAME]).To(Equal(`[default]
+region = us-east-1
+role_arn = arn:minio:iam:::role/kwimount
+web_identity_token_file = /var/run/kwimount-minio-service-account/token
+services = kwimount-minio
+
+[services kwimount-minio]
+sts =
+  endpoint_url = http://minio.minio.svc:9000
+s3 =
+  endpoint_url = http://minio.minio.svc:9000
+`))
+
+		Expect(envMap(w)).To(HaveKeyWithValue(AWS_CONFIG_FILE_ENV, MINIO_CONFIGURATION_MOUNT_PATH+MINIO_CONFIGURATION_FILE_NAME))
+		Expect(*w.Volumes[1].Projected.Sources[0].ServiceAccountToken.Audience).To(Equal("minio"))
+	})
+
+	It("Should reject a role ARN spanning multiple lines", func() {
+		pr := newProvider()
+		pr.Spec.MinIO.RoleARN = "arn:minio:iam:::role/kwimount\nregion = evil"
+		b, err := Get(pr.Spec.Target)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(b.Validate(&pr.Spec)).NotTo(Succeed())
+	})
+})
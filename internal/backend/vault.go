@@ -0,0 +1,149 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+
+	k8sv1alpha1 "github.com/piny940/kwimount/api/v1alpha1"
+)
+
+const (
+	VAULT_TOKEN_MOUNT_PATH         = "/var/run/kwimount-vault-service-account/"
+	VAULT_TOKEN_PATH               = "token"
+	VAULT_TOKEN_VOLUME_NAME        = "kwimount-vault-token"
+	VAULT_CONFIGURATION_MOUNT_PATH = "/etc/kwimount-vault/"
+	VAULT_CONFIGURATION_FILE_NAME  = "vault-agent.hcl"
+	VAULT_SINK_MOUNT_PATH          = "/var/run/kwimount-vault-agent/"
+	VAULT_SINK_PATH                = "token"
+	VAULT_SINK_VOLUME_NAME         = "kwimount-vault-agent"
+	VAULT_AGENT_CONTAINER_NAME     = "kwimount-vault-agent"
+	VAULT_CONF_BASE                = `vault {
+  address = %q
+}
+
+auto_auth {
+  method "jwt" {
+    mount_path = %q
+    config = {
+      path                     = %q
+      role                     = %q
+      remove_jwt_after_reading = false
+    }
+  }
+
+  sink "file" {
+    config = {
+      path = %q
+    }
+  }
+}
+`
+	VAULT_ADDR_ENV = "VAULT_ADDR"
+)
+
+type vault struct{}
+
+var _ Backend = &vault{}
+
+func init() {
+	Register(k8sv1alpha1.ProviderTargetTypeVault, &vault{})
+}
+
+func (v *vault) Validate(spec *k8sv1alpha1.ProviderSpec) error {
+	path := field.NewPath("spec", "vault")
+	if spec.Vault == nil {
+		return field.Required(path, "vault cannot be empty when target is vault")
+	}
+	u, err := url.Parse(spec.Vault.Address)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return field.Invalid(path.Child("address"), spec.Vault.Address, "address must be an http or https URL")
+	}
+	if spec.Vault.Role == "" {
+		return field.Invalid(path.Child("role"), spec.Vault.Role, "role cannot be empty")
+	}
+	return nil
+}
+
+func (v *vault) ValidateWorkloadIdentity(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider) error {
+	if pr.Spec.Vault == nil {
+		return fmt.Errorf("vault is required for provider target %s", pr.Spec.Target)
+	}
+	return nil
+}
+
+func (v *vault) Audience(pr *k8sv1alpha1.Provider) string {
+	return pr.Spec.Vault.Audience
+}
+
+// ConfigData renders a Vault Agent configuration that logs in with the
+// projected token and writes the Vault token to the sink volume.
+func (v *vault) ConfigData(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider) (map[string]string, error) {
+	return map[string]string{
+		VAULT_CONFIGURATION_FILE_NAME: fmt.Sprintf(VAULT_CONF_BASE,
+			pr.Spec.Vault.Address,
+			"auth/"+strings.Trim(pr.Spec.Vault.AuthMountPath, "/"),
+			VAULT_TOKEN_MOUNT_PATH+VAULT_TOKEN_PATH,
+			pr.Spec.Vault.Role,
+			VAULT_SINK_MOUNT_PATH+VAULT_SINK_PATH,
+		)}, nil
+}
+
+func (v *vault) Workload(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider, configMapName string) *Workload {
+	w := &Workload{
+		Env: []*corev1apply.EnvVarApplyConfiguration{
+			Env(VAULT_ADDR_ENV, pr.Spec.Vault.Address),
+		},
+		VolumeMounts: []*corev1apply.VolumeMountApplyConfiguration{
+			ReadOnlyMount(VAULT_TOKEN_VOLUME_NAME, VAULT_TOKEN_MOUNT_PATH),
+			ReadOnlyMount(configMapName, VAULT_CONFIGURATION_MOUNT_PATH),
+		},
+		Volumes: []*corev1apply.VolumeApplyConfiguration{
+			ConfigMapVolume(configMapName),
+		},
+		Token: SingleToken(VAULT_TOKEN_VOLUME_NAME, v.Audience(pr), VAULT_TOKEN_PATH),
+	}
+	if pr.Spec.Vault.Agent == nil {
+		return w
+	}
+	w.VolumeMounts = append(w.VolumeMounts, ReadOnlyMount(VAULT_SINK_VOLUME_NAME, VAULT_SINK_MOUNT_PATH))
+	w.Volumes = append(w.Volumes, corev1apply.Volume().
+		WithName(VAULT_SINK_VOLUME_NAME).
+		WithEmptyDir(corev1apply.EmptyDirVolumeSource().
+			WithMedium("Memory"),
+		),
+	)
+	w.Containers = append(w.Containers, corev1apply.Container().
+		WithName(VAULT_AGENT_CONTAINER_NAME).
+		WithImage(pr.Spec.Vault.Agent.Image).
+		WithArgs("agent", "-config="+VAULT_CONFIGURATION_MOUNT_PATH+VAULT_CONFIGURATION_FILE_NAME).
+		WithEnv(Env(VAULT_ADDR_ENV, pr.Spec.Vault.Address)).
+		WithVolumeMounts(
+			ReadOnlyMount(VAULT_TOKEN_VOLUME_NAME, VAULT_TOKEN_MOUNT_PATH),
+			ReadOnlyMount(configMapName, VAULT_CONFIGURATION_MOUNT_PATH),
+			corev1apply.VolumeMount().
+				WithName(VAULT_SINK_VOLUME_NAME).
+				WithMountPath(VAULT_SINK_MOUNT_PATH),
+		),
+	)
+	return w
+}
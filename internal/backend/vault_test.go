@@ -0,0 +1,88 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	k8sv1alpha1 "github.com/piny940/kwimount/api/v1alpha1"
+)
+
+var _ = Describe("Vault Backend", func() {
+	wi := &k8sv1alpha1.WorkloadIdentity{
+		ObjectMeta: metav1.ObjectMeta{Name: "wi", Namespace: "default"},
+		Spec:       k8sv1alpha1.WorkloadIdentitySpec{Deployment: "app"},
+	}
+	newProvider := func(agent *k8sv1alpha1.VaultAgent) *k8sv1alpha1.Provider {
+		return &k8sv1alpha1.Provider{
+			Spec: k8sv1alpha1.ProviderSpec{
+				Target: k8sv1alpha1.ProviderTargetTypeVault,
+				Vault: &k8sv1alpha1.VaultProvider{
+					Address:       "https://vault.example.com:8200",
+					AuthMountPath: "/kubernetes-jwt/",
+					Role:          "app\"role",
+					Audience:      "vault",
+					Agent:         agent,
+				},
+			},
+		}
+	}
+
+	It("Should render the agent configuration", func() {
+		pr := newProvider(nil)
+		b, err := Get(pr.Spec.Target)
+		Expect(err).NotTo(HaveOccurred())
+		data, err := b.ConfigData(wi, pr)
+		Expect(err).NotTo(HaveOccurred())
+		conf := data[VAULT_CONFIGURATION_FILE_NAME]
+		Expect(conf).To(ContainSubstring(`address = "https://vault.example.com:8200"`))
+		Expect(conf).To(ContainSubstring(`mount_path = "auth/kubernetes-jwt"`))
+		Expect(conf).To(ContainSubstring(`role                     = "app\"role"`))
+		Expect(conf).To(ContainSubstring(`path                     = "` + VAULT_TOKEN_MOUNT_PATH + VAULT_TOKEN_PATH + `"`))
+	})
+
+	It("Should not inject a sidecar unless the agent is enabled", func() {
+		pr := newProvider(nil)
+		b, err := Get(pr.Spec.Target)
+		Expect(err).NotTo(HaveOccurred())
+		w := b.Workload(wi, pr, "conf")
+		Expect(w.Containers).To(BeEmpty())
+		Expect(envMap(w)).To(HaveKeyWithValue(VAULT_ADDR_ENV, "https://vault.example.com:8200"))
+	})
+
+	It("Should inject the agent sidecar", func() {
+		pr := newProvider(&k8sv1alpha1.VaultAgent{Image: "hashicorp/vault:1.17"})
+		b, err := Get(pr.Spec.Target)
+		Expect(err).NotTo(HaveOccurred())
+		_, w, err := Render(b, wi, pr, "conf")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(w.Containers).To(HaveLen(1))
+		Expect(*w.Containers[0].Name).To(Equal(VAULT_AGENT_CONTAINER_NAME))
+		Expect(*w.Containers[0].Image).To(Equal("hashicorp/vault:1.17"))
+		Expect(w.Volumes).To(HaveLen(3))
+	})
+
+	It("Should reject an invalid address", func() {
+		pr := newProvider(nil)
+		pr.Spec.Vault.Address = "vault.example.com"
+		b, err := Get(pr.Spec.Target)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(b.Validate(&pr.Spec)).NotTo(Succeed())
+	})
+})
@@ -28,6 +28,7 @@ import (
 
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/envtest"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
@@ -42,6 +43,10 @@ import (
 
 var cfg *rest.Config
 var k8sClient client.Client
+
+// indexedClient reads WorkloadIdentities from a cache with the field indexes
+// of the controller, as the manager's client does.
+var indexedClient client.Client
 var testEnv *envtest.Environment
 var ctx context.Context
 var cancel context.CancelFunc
@@ -86,6 +91,19 @@ var _ = BeforeSuite(func() {
 	Expect(err).NotTo(HaveOccurred())
 	Expect(k8sClient).NotTo(BeNil())
 
+	informers, err := cache.New(cfg, cache.Options{Scheme: scheme.Scheme})
+	Expect(err).NotTo(HaveOccurred())
+	Expect(indexWorkloadIdentities(ctx, informers)).To(Succeed())
+	go func() {
+		defer GinkgoRecover()
+		Expect(informers.Start(ctx)).To(Succeed())
+	}()
+	Expect(informers.WaitForCacheSync(ctx)).To(BeTrue())
+	indexedClient, err = client.New(cfg, client.Options{
+		Scheme: scheme.Scheme,
+		Cache:  &client.CacheOptions{Reader: informers},
+	})
+	Expect(err).NotTo(HaveOccurred())
 })
 
 var _ = AfterSuite(func() {
@@ -18,25 +18,37 @@ package controller
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"time"
+	"maps"
+	"slices"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
 	appsv1apply "k8s.io/client-go/applyconfigurations/apps/v1"
 	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
 	"k8s.io/utils/ptr"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	k8sv1alpha1 "github.com/piny940/kwimount/api/v1alpha1"
+	"github.com/piny940/kwimount/internal/backend"
 )
 
 // WorkloadIdentityReconciler reconciles a WorkloadIdentity object
@@ -46,22 +58,36 @@ type WorkloadIdentityReconciler struct {
 }
 
 const (
-	FIELD_MANAGER                = "kwimount"
-	GCP_TOKEN_MOUNT_PATH         = "/var/run/kwimount-gcp-service-account/"
-	GCP_TOKEN_PATH               = "token"
-	GCP_CONFIGURATION_MOUNT_PATH = "/etc/kwimount-gcp-workload-identity/"
-	GCP_CONFIGURATION_FILE_NAME  = "gcp-credential-configuration.json"
-	GCP_TOKEN_VOLUME_NAME        = "kwimount-gcp-token"
-	GCP_TOKEN_AUDIENCE           = "https://iam.googleapis.com/projects/%s/locations/%s/workloadIdentityPools/%s/providers/%s"
-	TOKEN_EXPIRATION_SEC         = 3600
-	RETRY_INTERVAL               = 24 * time.Hour
+	// FIELD_MANAGER applies the ServiceAccounts and prefixes the field
+	// manager of each WorkloadIdentity on its Deployment.
+	FIELD_MANAGER = "kwimount"
+	FINALIZER     = "k8s.piny940.com/cleanup"
+
+	// CONFIG_HASH_ANNOTATION_PREFIX prefixes the pod template annotation,
+	// named after the WorkloadIdentity, that rolls out credential config changes.
+	CONFIG_HASH_ANNOTATION_PREFIX = "config-hash.k8s.piny940.com/"
+
+	// DEPLOYMENT_INDEX indexes WorkloadIdentities by spec.deployment.
+	DEPLOYMENT_INDEX = "spec.deployment"
+	// PROVIDER_INDEX indexes WorkloadIdentities by the namespace/name of spec.provider.
+	PROVIDER_INDEX = "spec.provider"
 )
 
+var errServiceAccountShared = errors.New("ServiceAccount is shared with another WorkloadIdentity")
+
+var CERTIFICATE_GVK = schema.GroupVersionKind{
+	Group:   "cert-manager.io",
+	Version: "v1",
+	Kind:    "Certificate",
+}
+
 // +kubebuilder:rbac:groups=k8s.piny940.com,resources=workloadidentities,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=k8s.piny940.com,resources=workloadidentities/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=k8s.piny940.com,resources=workloadidentities/finalizers,verbs=update
 // +kubebuilder:rbac:groups=k8s.piny940.com,resources=providers,verbs=get;list;watch
 // +kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=serviceaccounts,verbs=get;list;watch;patch
+// +kubebuilder:rbac:groups=cert-manager.io,resources=certificates,verbs=get;list;watch;create;update;patch;delete
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -73,87 +99,201 @@ func (r *WorkloadIdentityReconciler) Reconcile(ctx context.Context, req ctrl.Req
 	var wi k8sv1alpha1.WorkloadIdentity
 	err := r.Client.Get(ctx, req.NamespacedName, &wi)
 	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
 		logger.Error(err, "unable to fetch WorkloadIdentity")
 		return ctrl.Result{}, err
 	}
+	if !wi.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, r.finalize(ctx, &wi)
+	}
+	if !controllerutil.ContainsFinalizer(&wi, FINALIZER) {
+		controllerutil.AddFinalizer(&wi, FINALIZER)
+		err = r.Update(ctx, &wi)
+		if err != nil {
+			logger.Error(err, "unable to add finalizer")
+			return ctrl.Result{}, err
+		}
+	}
+	if previous := previousTarget(&wi); previous != nil {
+		logger.Info("deployment was retargeted, cleaning up the previous one", "previous", previous.Spec.Deployment)
+		err = r.cleanup(ctx, previous)
+		if err != nil {
+			return ctrl.Result{}, r.reportCleanupBlocked(ctx, &wi, err)
+		}
+		wi.Status.AppliedDeployment = ""
+		wi.Status.Certificate = ""
+		// Record the cleanup right away, as the new target may not exist yet.
+		if fail := meta.FindStatusCondition(wi.Status.Conditions, k8sv1alpha1.TypeWorkloadIdentityFail); fail != nil && fail.Reason == "CleanupBlocked" {
+			meta.SetStatusCondition(&wi.Status.Conditions, metav1.Condition{
+				Type:   k8sv1alpha1.TypeWorkloadIdentityFail,
+				Status: metav1.ConditionFalse,
+				Reason: "CleanedUp",
+			})
+		}
+		err = r.Status().Update(ctx, &wi)
+		if err != nil {
+			logger.Error(err, "unable to update WorkloadIdentity status")
+			return ctrl.Result{}, err
+		}
+	}
 
 	var provider k8sv1alpha1.Provider
-	err = r.Client.Get(ctx, client.ObjectKey{
-		Namespace: wi.Spec.Provider.Namespace,
-		Name:      wi.Spec.Provider.Name,
-	}, &provider)
-	if err != nil {
-		logger.Info("unable to fetch Provider with name: %s, namespace: %s. Will retry in %d seconds",
-			wi.Spec.Provider.Name,
-			wi.Spec.Provider.Namespace,
-			int(RETRY_INTERVAL.Seconds()),
+	key := providerKey(&wi)
+	err = r.Client.Get(ctx, key, &provider)
+	if apierrors.IsNotFound(err) {
+		logger.Info("Provider not found, waiting for it to be created",
+			"name", key.Name,
+			"namespace", key.Namespace,
 		)
-		return ctrl.Result{RequeueAfter: RETRY_INTERVAL}, err
+		return ctrl.Result{}, nil
+	}
+	if err != nil {
+		logger.Error(err, "unable to fetch Provider")
+		return ctrl.Result{}, err
+	}
+	b, err := backend.Get(provider.Spec.Target)
+	if err != nil {
+		logger.Error(err, "unable to find backend")
+		return ctrl.Result{}, err
+	}
+	err = b.ValidateWorkloadIdentity(&wi, &provider)
+	if err != nil {
+		// Retrying cannot fix the spec; it is reconciled again once the
+		// WorkloadIdentity or the Provider is updated.
+		logger.Info("invalid WorkloadIdentity", "reason", err.Error())
+		meta.SetStatusCondition(&wi.Status.Conditions, metav1.Condition{
+			Type:    k8sv1alpha1.TypeWorkloadIdentityFail,
+			Status:  metav1.ConditionTrue,
+			Reason:  "InvalidSpec",
+			Message: err.Error(),
+		})
+		meta.SetStatusCondition(&wi.Status.Conditions, metav1.Condition{
+			Type:   k8sv1alpha1.TypeWorkloadIdentityDone,
+			Status: metav1.ConditionFalse,
+			Reason: "InvalidSpec",
+		})
+		return ctrl.Result{}, r.Status().Update(ctx, &wi)
 	}
-	err = r.reconcileConfigMap(ctx, &wi, &provider)
+	data, workload, err := backend.Render(b, &wi, &provider, configMapName(&wi))
 	if err != nil {
+		logger.Error(err, "unable to render credentials")
 		return ctrl.Result{}, err
 	}
+	err = r.reconcileConfigMap(ctx, &wi, data)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if workload.Certificate != nil {
+		err = r.reconcileCertificate(ctx, &wi, workload.Certificate)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		wi.Status.Certificate = workload.Certificate.Name
+	} else {
+		err = r.deleteCertificate(ctx, &wi)
+		if err != nil {
+			logger.Error(err, "unable to delete Certificate")
+			return ctrl.Result{}, err
+		}
+	}
 	dep := &appsv1.Deployment{}
 	err = r.Client.Get(ctx, client.ObjectKey{
 		Namespace: wi.Namespace,
 		Name:      wi.Spec.Deployment,
 	}, dep)
-	if err != nil {
-		logger.Info("unable to fetch Deployment with name: %s, namespace: %s.",
-			wi.Spec.Deployment,
-			wi.Namespace,
+	if apierrors.IsNotFound(err) {
+		logger.Info("Deployment not found, waiting for it to be created",
+			"name", wi.Spec.Deployment,
+			"namespace", wi.Namespace,
 		)
+		return ctrl.Result{}, nil
+	}
+	if err != nil {
+		logger.Error(err, "unable to fetch Deployment")
 		return ctrl.Result{}, err
 	}
-	err = r.reconcileDeployment(ctx, &wi, &provider, dep)
+	err = r.reconcileDeployment(ctx, &wi, data, workload, dep)
 	if err != nil {
 		return ctrl.Result{}, err
 	}
-	err = r.updateStatus(ctx, &wi)
+	wi.Status.AppliedDeployment = wi.Spec.Deployment
+	wi.Status.ProviderGeneration = provider.Generation
+	if reporter, ok := b.(backend.PrincipalReporter); ok {
+		wi.Status.Principal = reporter.Principal(&wi, &provider, serviceAccountName(dep))
+	}
+	if checker, ok := b.(backend.ClaimChecker); ok {
+		if err := checker.CheckClaims(&wi, &provider, serviceAccountName(dep)); errors.Is(err, backend.ErrClaimsUnknown) {
+			logger.Info("unable to tell whether tokens of the workload would be accepted", "reason", err.Error())
+			meta.SetStatusCondition(&wi.Status.Conditions, metav1.Condition{
+				Type:    k8sv1alpha1.TypeWorkloadIdentityFail,
+				Status:  metav1.ConditionUnknown,
+				Reason:  "ClaimsUnknown",
+				Message: err.Error(),
+			})
+		} else if err != nil {
+			logger.Info("tokens of the workload would be rejected", "reason", err.Error())
+			meta.SetStatusCondition(&wi.Status.Conditions, metav1.Condition{
+				Type:    k8sv1alpha1.TypeWorkloadIdentityFail,
+				Status:  metav1.ConditionTrue,
+				Reason:  "ClaimsRejected",
+				Message: err.Error(),
+			})
+		} else {
+			meta.SetStatusCondition(&wi.Status.Conditions, metav1.Condition{
+				Type:   k8sv1alpha1.TypeWorkloadIdentityFail,
+				Status: metav1.ConditionFalse,
+				Reason: "ClaimsAccepted",
+			})
+		}
+	} else {
+		meta.SetStatusCondition(&wi.Status.Conditions, metav1.Condition{
+			Type:   k8sv1alpha1.TypeWorkloadIdentityFail,
+			Status: metav1.ConditionFalse,
+			Reason: "Reconciled",
+		})
+	}
+	err = r.reconcileServiceAccount(ctx, &wi, serviceAccountName(dep), workload)
+	if errors.Is(err, errServiceAccountShared) {
+		logger.Info("ServiceAccount is shared with another WorkloadIdentity", "reason", err.Error())
+		meta.SetStatusCondition(&wi.Status.Conditions, metav1.Condition{
+			Type:    k8sv1alpha1.TypeWorkloadIdentityFail,
+			Status:  metav1.ConditionTrue,
+			Reason:  "ServiceAccountShared",
+			Message: err.Error(),
+		})
+	} else if err != nil {
+		return ctrl.Result{}, err
+	}
+	err = r.updateStatus(ctx, &wi, workload)
 	if err != nil {
 		return ctrl.Result{}, err
 	}
 
-	return ctrl.Result{RequeueAfter: RETRY_INTERVAL}, nil
+	return ctrl.Result{}, nil
 }
 
-const (
-	GCP_CONF_BASE = `{
-  "universe_domain": "googleapis.com",
-  "type": "external_account",
-  "audience": "//iam.googleapis.com/projects/%s/locations/%s/workloadIdentityPools/%s/providers/%s",
-  "subject_token_type": "urn:ietf:params:oauth:token-type:jwt",
-  "token_url": "https://sts.googleapis.com/v1/token",
-  "credential_source": {
-    "file": "%s",
-    "format": {
-      "type": "text"
-    }
-  },
-  "service_account_impersonation_url": "https://iamcredentials.googleapis.com/v1/projects/-/serviceAccounts/%s:generateAccessToken"
-}`
-	GOOGLE_CREDENTIALS_ENV = "GOOGLE_APPLICATION_CREDENTIALS"
-)
-
-func (r *WorkloadIdentityReconciler) reconcileConfigMap(ctx context.Context, wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider) error {
+// reconcileConfigMap creates or updates the ConfigMap holding the rendered
+// files. Targets rendering no files get no ConfigMap, and the one left from a
+// previous target is deleted.
+func (r *WorkloadIdentityReconciler) reconcileConfigMap(ctx context.Context, wi *k8sv1alpha1.WorkloadIdentity, data map[string]string) error {
 	logger := log.FromContext(ctx)
 
 	cm := &corev1.ConfigMap{}
 	cm.SetNamespace(wi.Namespace)
 	cm.SetName(configMapName(wi))
-	op, err := ctrl.CreateOrUpdate(ctx, r.Client, cm, func() error {
-		if cm.Data == nil {
-			switch pr.Spec.Target {
-			case k8sv1alpha1.ProviderTargetTypeGCP:
-				cm.Data = gcpConfigMapData(wi, pr)
-			default:
-				err := fmt.Errorf("unsupported provider target type %s", pr.Spec.Target)
-				logger.Error(err, "unable to createOrUpdate ConfigMap")
-				return err
-			}
+	if len(data) == 0 {
+		err := r.Delete(ctx, cm)
+		if client.IgnoreNotFound(err) != nil {
+			logger.Error(err, "unable to delete ConfigMap")
+			return err
 		}
 		return nil
+	}
+	op, err := ctrl.CreateOrUpdate(ctx, r.Client, cm, func() error {
+		cm.Data = data
+		return ctrl.SetControllerReference(wi, cm, r.Scheme)
 	})
 	if err != nil {
 		logger.Error(err, "unable to createOrUpdate ConfigMap")
@@ -167,113 +307,393 @@ func (r *WorkloadIdentityReconciler) reconcileConfigMap(ctx context.Context, wi
 	return nil
 }
 
-func gcpConfigMapData(wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider) map[string]string {
-	return map[string]string{
-		GCP_CONFIGURATION_FILE_NAME: fmt.Sprintf(GCP_CONF_BASE,
-			pr.Spec.Project.Number,
-			pr.Spec.Location,
-			pr.Spec.PoolID,
-			pr.Spec.ProviderID,
-			GCP_TOKEN_MOUNT_PATH+GCP_TOKEN_PATH,
-			wi.Spec.TargetServiceAccount,
-		)}
+func (r *WorkloadIdentityReconciler) reconcileCertificate(ctx context.Context, wi *k8sv1alpha1.WorkloadIdentity, certificate *backend.Certificate) error {
+	logger := log.FromContext(ctx)
+
+	cert := &unstructured.Unstructured{}
+	cert.SetGroupVersionKind(CERTIFICATE_GVK)
+	cert.SetNamespace(wi.Namespace)
+	cert.SetName(certificate.Name)
+	op, err := ctrl.CreateOrUpdate(ctx, r.Client, cert, func() error {
+		spec := map[string]interface{}{
+			"secretName": certificate.SecretName,
+			"commonName": certificate.CommonName,
+			"issuerRef": map[string]interface{}{
+				"name":  certificate.IssuerRef.Name,
+				"kind":  certificate.IssuerRef.Kind,
+				"group": certificate.IssuerRef.Group,
+			},
+			"usages": []interface{}{"client auth", "digital signature", "key encipherment"},
+		}
+		if certificate.Duration != nil {
+			spec["duration"] = certificate.Duration.Duration.String()
+		}
+		if err := unstructured.SetNestedMap(cert.Object, spec, "spec"); err != nil {
+			return err
+		}
+		return ctrl.SetControllerReference(wi, cert, r.Scheme)
+	})
+	if err != nil {
+		logger.Error(err, "unable to createOrUpdate Certificate")
+		return err
+	}
+	if op == controllerutil.OperationResultNone {
+		logger.Info("Certificate is up to date")
+	} else {
+		logger.Info("successfully reconciled Certificate", "operation", op)
+	}
+	return nil
 }
 
-func (r *WorkloadIdentityReconciler) reconcileDeployment(ctx context.Context, wi *k8sv1alpha1.WorkloadIdentity, pr *k8sv1alpha1.Provider, current *appsv1.Deployment) error {
+func (r *WorkloadIdentityReconciler) reconcileDeployment(ctx context.Context, wi *k8sv1alpha1.WorkloadIdentity, data map[string]string, workload *backend.Workload, current *appsv1.Deployment) error {
 	logger := log.FromContext(ctx)
 
-	containers := make([]*corev1apply.ContainerApplyConfiguration, 0, len(current.Spec.Template.Spec.Containers))
+	podAnnotations := maps.Clone(workload.PodAnnotations)
+	if !wi.Spec.DisableAutoRollout {
+		hash, err := configHash(data, workload)
+		if err != nil {
+			logger.Error(err, "unable to hash credential config")
+			return err
+		}
+		if podAnnotations == nil {
+			podAnnotations = map[string]string{}
+		}
+		podAnnotations[configHashAnnotation(wi)] = hash
+	}
+
+	containers := make([]*corev1apply.ContainerApplyConfiguration, 0, len(current.Spec.Template.Spec.Containers)+len(workload.Containers))
 	for _, container := range current.Spec.Template.Spec.Containers {
+		if slices.ContainsFunc(workload.Containers, func(sidecar *corev1apply.ContainerApplyConfiguration) bool {
+			return *sidecar.Name == container.Name
+		}) {
+			continue
+		}
 		containers = append(containers, corev1apply.Container().
 			WithName(container.Name).
-			WithEnv(corev1apply.EnvVar().
-				WithName(GOOGLE_CREDENTIALS_ENV).
-				WithValue(GCP_CONFIGURATION_MOUNT_PATH+GCP_CONFIGURATION_FILE_NAME),
-			).
-			WithVolumeMounts(
-				corev1apply.VolumeMount().
-					WithName(GCP_TOKEN_VOLUME_NAME).
-					WithMountPath(GCP_TOKEN_MOUNT_PATH).
-					WithReadOnly(true),
-				corev1apply.VolumeMount().
-					WithName(configMapName(wi)).
-					WithMountPath(GCP_CONFIGURATION_MOUNT_PATH).
-					WithReadOnly(true),
-			))
-	}
-	audience := fmt.Sprintf(GCP_TOKEN_AUDIENCE, pr.Spec.Project.Number, pr.Spec.Location, pr.Spec.PoolID, pr.Spec.ProviderID)
+			WithEnv(workload.Env...).
+			WithVolumeMounts(workload.VolumeMounts...))
+	}
+	containers = append(containers, workload.Containers...)
+	template := corev1apply.PodTemplateSpec().
+		WithSpec(corev1apply.PodSpec().
+			WithContainers(containers...).
+			WithVolumes(workload.Volumes...),
+		)
+	// Setting empty labels or annotations would add a metadata block that is
+	// never extracted back, so the Deployment would be applied every time.
+	if len(workload.PodLabels) > 0 {
+		template.WithLabels(workload.PodLabels)
+	}
+	if len(podAnnotations) > 0 {
+		template.WithAnnotations(podAnnotations)
+	}
 	expected := appsv1apply.Deployment(wi.Spec.Deployment, wi.Namespace).
 		WithSpec(appsv1apply.DeploymentSpec().
-			WithTemplate(corev1apply.PodTemplateSpec().
-				WithSpec(corev1apply.PodSpec().
-					WithContainers(containers...).
-					WithVolumes(
-						corev1apply.Volume().
-							WithName(configMapName(wi)).
-							WithConfigMap(corev1apply.ConfigMapVolumeSource().
-								WithName(configMapName(wi)),
-							),
-						corev1apply.Volume().
-							WithName(GCP_TOKEN_VOLUME_NAME).
-							WithProjected(
-								corev1apply.ProjectedVolumeSource().
-									WithSources(corev1apply.VolumeProjection().
-										WithServiceAccountToken(
-											corev1apply.ServiceAccountTokenProjection().
-												WithAudience(audience).
-												WithExpirationSeconds(TOKEN_EXPIRATION_SEC).
-												WithPath(GCP_TOKEN_PATH),
-										),
-									),
-							),
-					),
-				),
-			),
+			WithTemplate(template),
 		)
-	currentApply, err := appsv1apply.ExtractDeployment(current, FIELD_MANAGER)
+	manager := fieldManager(wi)
+	currentApply, err := appsv1apply.ExtractDeployment(current, manager)
 	if err != nil {
 		logger.Error(err, "unable to extract current Deployment")
 		return err
 	}
 	if equality.Semantic.DeepEqual(expected, currentApply) {
 		logger.Info("Deployment is up to date")
+	} else {
+		err = r.apply(ctx, expected, manager)
+		if err != nil {
+			logger.Error(err, "unable to patch Deployment")
+			return err
+		}
+		logger.Info("successfully patched Deployment with name: %s, namespace: %s", wi.Spec.Deployment, wi.Namespace)
+	}
+	// Deployments patched before each WorkloadIdentity had its own field
+	// manager are still owned by FIELD_MANAGER. The fields are now co-owned
+	// by manager, so releasing them keeps what is still rendered.
+	if managedBy(current, FIELD_MANAGER) {
+		err = r.apply(ctx, appsv1apply.Deployment(wi.Spec.Deployment, wi.Namespace), FIELD_MANAGER)
+		if err != nil {
+			logger.Error(err, "unable to release legacy fields of Deployment")
+			return err
+		}
+	}
+	return nil
+}
+
+// reconcileServiceAccount applies the annotations and labels of the workload
+// to the ServiceAccount, releasing the ones no longer rendered. All
+// WorkloadIdentities share FIELD_MANAGER, so a ServiceAccount already managed
+// by another WorkloadIdentity is left untouched and errServiceAccountShared
+// is returned.
+func (r *WorkloadIdentityReconciler) reconcileServiceAccount(ctx context.Context, wi *k8sv1alpha1.WorkloadIdentity, name string, workload *backend.Workload) error {
+	logger := log.FromContext(ctx)
+	namespace := wi.Namespace
+	managed := len(workload.ServiceAccountAnnotations) > 0 || len(workload.ServiceAccountLabels) > 0
+
+	if wi.Status.ServiceAccount != "" && wi.Status.ServiceAccount != name {
+		err := r.releaseServiceAccount(ctx, wi)
+		if err != nil {
+			return err
+		}
+	}
+	owner, err := r.serviceAccountOwner(ctx, wi, name)
+	if err != nil {
+		return err
+	}
+	if owner != "" {
+		if managed {
+			return fmt.Errorf("%w: %s is managed by WorkloadIdentity %s", errServiceAccountShared, name, owner)
+		}
+		return nil
+	}
+
+	current := &corev1.ServiceAccount{}
+	err = r.Client.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, current)
+	if apierrors.IsNotFound(err) && !managed {
+		return nil
+	}
+	if err != nil {
+		logger.Error(err, "unable to fetch ServiceAccount", "name", name, "namespace", namespace)
+		return err
+	}
+	if managed {
+		wi.Status.ServiceAccount = name
+	} else {
+		wi.Status.ServiceAccount = ""
+	}
+	expected := corev1apply.ServiceAccount(name, namespace).
+		WithAnnotations(workload.ServiceAccountAnnotations).
+		WithLabels(workload.ServiceAccountLabels)
+	currentApply, err := corev1apply.ExtractServiceAccount(current, FIELD_MANAGER)
+	if err != nil {
+		logger.Error(err, "unable to extract current ServiceAccount")
+		return err
+	}
+	if equality.Semantic.DeepEqual(expected, currentApply) {
+		logger.Info("ServiceAccount is up to date")
 		return nil
 	}
-	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(expected)
+	err = r.apply(ctx, expected, FIELD_MANAGER)
+	if err != nil {
+		logger.Error(err, "unable to patch ServiceAccount")
+		return err
+	}
+	logger.Info("successfully patched ServiceAccount", "name", name, "namespace", namespace)
+	return nil
+}
+
+// releaseServiceAccount releases the fields applied to the ServiceAccount
+// recorded in the status, unless another WorkloadIdentity manages it.
+func (r *WorkloadIdentityReconciler) releaseServiceAccount(ctx context.Context, wi *k8sv1alpha1.WorkloadIdentity) error {
+	name := wi.Status.ServiceAccount
+	if name == "" {
+		return nil
+	}
+	owner, err := r.serviceAccountOwner(ctx, wi, name)
+	if err != nil {
+		return err
+	}
+	if owner == "" {
+		sa := &corev1.ServiceAccount{}
+		err = r.Client.Get(ctx, client.ObjectKey{Namespace: wi.Namespace, Name: name}, sa)
+		if client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("unable to fetch ServiceAccount %s: %w", name, err)
+		}
+		if err == nil && managedBy(sa, FIELD_MANAGER) {
+			err = r.apply(ctx, corev1apply.ServiceAccount(sa.Name, sa.Namespace), FIELD_MANAGER)
+			if err != nil {
+				return fmt.Errorf("unable to release ServiceAccount %s: %w", sa.Name, err)
+			}
+		}
+	}
+	wi.Status.ServiceAccount = ""
+	return nil
+}
+
+// serviceAccountOwner returns the name of another WorkloadIdentity in the
+// namespace managing the ServiceAccount, or an empty string.
+func (r *WorkloadIdentityReconciler) serviceAccountOwner(ctx context.Context, wi *k8sv1alpha1.WorkloadIdentity, name string) (string, error) {
+	var wis k8sv1alpha1.WorkloadIdentityList
+	err := r.List(ctx, &wis, client.InNamespace(wi.Namespace))
+	if err != nil {
+		return "", fmt.Errorf("unable to list WorkloadIdentities: %w", err)
+	}
+	for _, other := range wis.Items {
+		if other.Name != wi.Name && other.Status.ServiceAccount == name {
+			return other.Name, nil
+		}
+	}
+	return "", nil
+}
+
+// configHash returns a hash of the rendered credential config and the volumes
+// projecting it, so that the pods are rolled out whenever either changes.
+func configHash(data map[string]string, workload *backend.Workload) (string, error) {
+	b, err := json.Marshal(struct {
+		Data    map[string]string                       `json:"data"`
+		Volumes []*corev1apply.VolumeApplyConfiguration `json:"volumes"`
+	}{data, workload.Volumes})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(b)), nil
+}
+
+// apply server-side applies the given apply configuration as manager.
+// Applying a configuration without any fields releases every field manager
+// owns on the object.
+func (r *WorkloadIdentityReconciler) apply(ctx context.Context, config interface{}, manager string) error {
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(config)
 	if err != nil {
-		logger.Error(err, "unable to convert Deployment to unstructured")
 		return err
 	}
 	patch := &unstructured.Unstructured{Object: obj}
-	err = r.Patch(ctx, patch, client.Apply, &client.PatchOptions{
-		FieldManager: FIELD_MANAGER,
+	return r.Patch(ctx, patch, client.Apply, &client.PatchOptions{
+		FieldManager: manager,
 		Force:        ptr.To(true),
 	})
+}
+
+// finalize removes everything kwimount injected for the WorkloadIdentity and
+// releases the finalizer. When the cleanup fails the object is kept and the
+// reason is reported through the Fail condition.
+func (r *WorkloadIdentityReconciler) finalize(ctx context.Context, wi *k8sv1alpha1.WorkloadIdentity) error {
+	logger := log.FromContext(ctx)
+
+	if !controllerutil.ContainsFinalizer(wi, FINALIZER) {
+		return nil
+	}
+	if previous := previousTarget(wi); previous != nil {
+		err := r.cleanup(ctx, previous)
+		if err != nil {
+			return r.reportCleanupBlocked(ctx, wi, err)
+		}
+	}
+	err := r.cleanup(ctx, wi)
+	if err != nil {
+		return r.reportCleanupBlocked(ctx, wi, err)
+	}
+	err = r.releaseServiceAccount(ctx, wi)
+	if err != nil {
+		return r.reportCleanupBlocked(ctx, wi, err)
+	}
+	controllerutil.RemoveFinalizer(wi, FINALIZER)
+	err = r.Update(ctx, wi)
 	if err != nil {
-		logger.Error(err, "unable to patch Deployment")
+		logger.Error(err, "unable to remove finalizer")
 		return err
 	}
-	logger.Info("successfully patched Deployment with name: %s, namespace: %s", wi.Spec.Deployment, wi.Namespace)
+	logger.Info("successfully cleaned up WorkloadIdentity")
 	return nil
 }
 
-func (r *WorkloadIdentityReconciler) updateStatus(ctx context.Context, wi *k8sv1alpha1.WorkloadIdentity) error {
+// reportCleanupBlocked records the cleanup failure in the Fail condition and
+// returns err so that the cleanup is retried.
+func (r *WorkloadIdentityReconciler) reportCleanupBlocked(ctx context.Context, wi *k8sv1alpha1.WorkloadIdentity, err error) error {
 	logger := log.FromContext(ctx)
 
-	cm := &corev1.ConfigMap{}
+	logger.Error(err, "unable to clean up WorkloadIdentity")
+	meta.SetStatusCondition(&wi.Status.Conditions, metav1.Condition{
+		Type:    k8sv1alpha1.TypeWorkloadIdentityFail,
+		Status:  metav1.ConditionTrue,
+		Reason:  "CleanupBlocked",
+		Message: err.Error(),
+	})
+	if statusErr := r.Status().Update(ctx, wi); statusErr != nil {
+		logger.Error(statusErr, "unable to update WorkloadIdentity status")
+	}
+	return err
+}
+
+// previousTarget returns a copy of wi pointing at the deployment the
+// credentials were last injected into, or nil if it is still the current one.
+func previousTarget(wi *k8sv1alpha1.WorkloadIdentity) *k8sv1alpha1.WorkloadIdentity {
+	if wi.Status.AppliedDeployment == "" || wi.Status.AppliedDeployment == wi.Spec.Deployment {
+		return nil
+	}
+	previous := wi.DeepCopy()
+	previous.Spec.Deployment = wi.Status.AppliedDeployment
+	return previous
+}
+
+// cleanup releases the fields of wi on the target Deployment and deletes the
+// ConfigMap and Certificate created for wi.
+func (r *WorkloadIdentityReconciler) cleanup(ctx context.Context, wi *k8sv1alpha1.WorkloadIdentity) error {
+	dep := &appsv1.Deployment{}
 	err := r.Client.Get(ctx, client.ObjectKey{
 		Namespace: wi.Namespace,
-		Name:      configMapName(wi),
-	}, cm)
+		Name:      wi.Spec.Deployment,
+	}, dep)
 	if client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("unable to fetch Deployment %s: %w", wi.Spec.Deployment, err)
+	}
+	if err == nil {
+		for _, manager := range []string{fieldManager(wi), FIELD_MANAGER} {
+			if !managedBy(dep, manager) {
+				continue
+			}
+			err = r.apply(ctx, appsv1apply.Deployment(dep.Name, dep.Namespace), manager)
+			if err != nil {
+				return fmt.Errorf("unable to release Deployment %s: %w", dep.Name, err)
+			}
+		}
+	}
+
+	cm := &corev1.ConfigMap{}
+	cm.SetNamespace(wi.Namespace)
+	cm.SetName(configMapName(wi))
+	err = r.Delete(ctx, cm)
+	if client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("unable to delete ConfigMap %s: %w", cm.Name, err)
+	}
+	return r.deleteCertificate(ctx, wi)
+}
+
+// deleteCertificate deletes the Certificate recorded in the status. Nothing
+// is requested from cert-manager if the WorkloadIdentity never created one.
+func (r *WorkloadIdentityReconciler) deleteCertificate(ctx context.Context, wi *k8sv1alpha1.WorkloadIdentity) error {
+	if wi.Status.Certificate == "" {
 		return nil
 	}
-	if err != nil {
-		return err
+	cert := &unstructured.Unstructured{}
+	cert.SetGroupVersionKind(CERTIFICATE_GVK)
+	cert.SetNamespace(wi.Namespace)
+	cert.SetName(wi.Status.Certificate)
+	err := r.Delete(ctx, cert)
+	if client.IgnoreNotFound(err) != nil && !meta.IsNoMatchError(err) {
+		return fmt.Errorf("unable to delete Certificate %s: %w", cert.GetName(), err)
+	}
+	wi.Status.Certificate = ""
+	return nil
+}
+
+func managedBy(obj metav1.Object, manager string) bool {
+	return slices.ContainsFunc(obj.GetManagedFields(), func(entry metav1.ManagedFieldsEntry) bool {
+		return entry.Manager == manager && entry.Operation == metav1.ManagedFieldsOperationApply
+	})
+}
+
+// fieldManager returns the field manager applying the Deployment of wi, so
+// that WorkloadIdentities sharing a Deployment keep each other's fields.
+func fieldManager(wi *k8sv1alpha1.WorkloadIdentity) string {
+	return fmt.Sprintf("%s/%s/%s", FIELD_MANAGER, wi.Namespace, wi.Name)
+}
+
+// configHashAnnotation returns the pod template annotation holding the config
+// hash of wi. Names too long for an annotation key are hashed.
+func configHashAnnotation(wi *k8sv1alpha1.WorkloadIdentity) string {
+	name := wi.Name
+	if len(name) > validation.LabelValueMaxLength {
+		name = fmt.Sprintf("%x", sha256.Sum256([]byte(name)))[:validation.LabelValueMaxLength]
 	}
+	return CONFIG_HASH_ANNOTATION_PREFIX + name
+}
+
+func (r *WorkloadIdentityReconciler) updateStatus(ctx context.Context, wi *k8sv1alpha1.WorkloadIdentity, workload *backend.Workload) error {
+	logger := log.FromContext(ctx)
 
 	dep := &appsv1.Deployment{}
-	err = r.Client.Get(ctx, client.ObjectKey{
+	err := r.Client.Get(ctx, client.ObjectKey{
 		Namespace: wi.Namespace,
 		Name:      wi.Spec.Deployment,
 	}, dep)
@@ -283,14 +703,24 @@ func (r *WorkloadIdentityReconciler) updateStatus(ctx context.Context, wi *k8sv1
 	if err != nil {
 		return err
 	}
-	volumeCreated := false
-	for _, volume := range dep.Spec.Template.Spec.Volumes {
-		if volume.Name == GCP_TOKEN_VOLUME_NAME {
-			volumeCreated = true
+	volumeCreated := true
+	for _, expected := range workload.Volumes {
+		if !slices.ContainsFunc(dep.Spec.Template.Spec.Volumes, func(v corev1.Volume) bool {
+			return v.Name == *expected.Name
+		}) {
+			volumeCreated = false
 			break
 		}
 	}
-	if volumeCreated {
+	// The credentials are not usable while the WorkloadIdentity fails, e.g.
+	// when the identity provider would reject the tokens.
+	if fail := meta.FindStatusCondition(wi.Status.Conditions, k8sv1alpha1.TypeWorkloadIdentityFail); fail != nil && fail.Status == metav1.ConditionTrue {
+		meta.SetStatusCondition(&wi.Status.Conditions, metav1.Condition{
+			Type:   k8sv1alpha1.TypeWorkloadIdentityDone,
+			Status: metav1.ConditionFalse,
+			Reason: fail.Reason,
+		})
+	} else if volumeCreated {
 		meta.SetStatusCondition(&wi.Status.Conditions, metav1.Condition{
 			Type:   k8sv1alpha1.TypeWorkloadIdentityDone,
 			Status: metav1.ConditionTrue,
@@ -305,14 +735,98 @@ func (r *WorkloadIdentityReconciler) updateStatus(ctx context.Context, wi *k8sv1
 	return nil
 }
 
+func serviceAccountName(dep *appsv1.Deployment) string {
+	if dep.Spec.Template.Spec.ServiceAccountName == "" {
+		return "default"
+	}
+	return dep.Spec.Template.Spec.ServiceAccountName
+}
+
 func configMapName(wi *k8sv1alpha1.WorkloadIdentity) string {
 	return fmt.Sprintf("kwimount-%s-%s-conf", wi.Name, wi.Spec.Deployment)
 }
 
+func indexByDeployment(obj client.Object) []string {
+	return []string{obj.(*k8sv1alpha1.WorkloadIdentity).Spec.Deployment}
+}
+
+func indexByProvider(obj client.Object) []string {
+	return []string{providerKey(obj.(*k8sv1alpha1.WorkloadIdentity)).String()}
+}
+
+// providerKey returns the key of the Provider referred to by wi. The namespace
+// defaults to the one of wi, as the defaulting webhook does.
+func providerKey(wi *k8sv1alpha1.WorkloadIdentity) client.ObjectKey {
+	namespace := wi.Spec.Provider.Namespace
+	if namespace == "" {
+		namespace = wi.Namespace
+	}
+	return client.ObjectKey{Namespace: namespace, Name: wi.Spec.Provider.Name}
+}
+
+// indexWorkloadIdentities registers the field indexes used to map events of
+// Deployments and Providers to WorkloadIdentities.
+func indexWorkloadIdentities(ctx context.Context, indexer client.FieldIndexer) error {
+	err := indexer.IndexField(ctx, &k8sv1alpha1.WorkloadIdentity{}, DEPLOYMENT_INDEX, indexByDeployment)
+	if err != nil {
+		return err
+	}
+	return indexer.IndexField(ctx, &k8sv1alpha1.WorkloadIdentity{}, PROVIDER_INDEX, indexByProvider)
+}
+
+// workloadIdentitiesForProvider maps a Provider to the WorkloadIdentities
+// referring to it, so that changes of the Provider are rendered again.
+func (r *WorkloadIdentityReconciler) workloadIdentitiesForProvider(ctx context.Context, obj client.Object) []reconcile.Request {
+	return r.workloadIdentitiesMatching(ctx, client.MatchingFields{
+		PROVIDER_INDEX: client.ObjectKeyFromObject(obj).String(),
+	})
+}
+
+// workloadIdentitiesForDeployment maps a Deployment to the WorkloadIdentities
+// targeting it, so that Deployments created later or drifted are repaired.
+func (r *WorkloadIdentityReconciler) workloadIdentitiesForDeployment(ctx context.Context, obj client.Object) []reconcile.Request {
+	return r.workloadIdentitiesMatching(ctx,
+		client.InNamespace(obj.GetNamespace()),
+		client.MatchingFields{DEPLOYMENT_INDEX: obj.GetName()},
+	)
+}
+
+func (r *WorkloadIdentityReconciler) workloadIdentitiesMatching(ctx context.Context, opts ...client.ListOption) []reconcile.Request {
+	logger := log.FromContext(ctx)
+
+	var wis k8sv1alpha1.WorkloadIdentityList
+	err := r.List(ctx, &wis, opts...)
+	if err != nil {
+		logger.Error(err, "unable to list WorkloadIdentities")
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(wis.Items))
+	for _, wi := range wis.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: client.ObjectKeyFromObject(&wi),
+		})
+	}
+	return requests
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *WorkloadIdentityReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	err := indexWorkloadIdentities(context.Background(), mgr.GetFieldIndexer())
+	if err != nil {
+		return err
+	}
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&k8sv1alpha1.WorkloadIdentity{}).
 		Owns(&corev1.ConfigMap{}).
+		Watches(
+			&appsv1.Deployment{},
+			handler.EnqueueRequestsFromMapFunc(r.workloadIdentitiesForDeployment),
+			builder.WithPredicates(predicate.GenerationChangedPredicate{}),
+		).
+		Watches(
+			&k8sv1alpha1.Provider{},
+			handler.EnqueueRequestsFromMapFunc(r.workloadIdentitiesForProvider),
+			builder.WithPredicates(predicate.GenerationChangedPredicate{}),
+		).
 		Complete(r)
 }
@@ -26,14 +26,20 @@ import (
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	appsv1apply "k8s.io/client-go/applyconfigurations/apps/v1"
 
 	k8sv1alpha1 "github.com/piny940/kwimount/api/v1alpha1"
+	"github.com/piny940/kwimount/internal/backend"
 )
 
 func sampleDeployment(name, namespace string) *appsv1.Deployment {
@@ -41,6 +47,9 @@ func sampleDeployment(name, namespace string) *appsv1.Deployment {
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      name,
 			Namespace: namespace,
+			Labels: map[string]string{
+				"app": "test-app",
+			},
 		},
 		Spec: appsv1.DeploymentSpec{
 			Selector: &metav1.LabelSelector{
@@ -88,6 +97,22 @@ var sampleProvider = k8sv1alpha1.Provider{
 	},
 }
 
+var sampleAWSProvider = k8sv1alpha1.Provider{
+	ObjectMeta: metav1.ObjectMeta{
+		Name:      "test-aws-provider",
+		Namespace: "default",
+	},
+	Spec: k8sv1alpha1.ProviderSpec{
+		Location: "global",
+		Target:   "aws",
+		AWS: &k8sv1alpha1.AWSProvider{
+			RoleARN:              "arn:aws:iam::123456789012:role/test-role",
+			Region:               "ap-northeast-1",
+			STSRegionalEndpoints: k8sv1alpha1.AWSSTSRegionalEndpointsRegional,
+		},
+	},
+}
+
 var _ = Describe("WorkloadIdentity Controller", func() {
 	Context("When reconciling a resource", func() {
 		const resourceName = "test-resource"
@@ -123,7 +148,6 @@ var _ = Describe("WorkloadIdentity Controller", func() {
 					},
 				}
 				Expect(k8sClient.Create(ctx, resource)).To(Succeed())
-				Expect(k8sClient.Create(ctx, &sampleProvider)).To(Succeed())
 			}
 			err = k8sClient.Get(ctx, client.ObjectKeyFromObject(&sampleProvider), &sampleProvider)
 			if err != nil && errors.IsNotFound(err) {
@@ -134,10 +158,13 @@ var _ = Describe("WorkloadIdentity Controller", func() {
 		AfterEach(func() {
 			resource := &k8sv1alpha1.WorkloadIdentity{}
 			err := k8sClient.Get(ctx, typeNamespacedName, resource)
-			Expect(err).NotTo(HaveOccurred())
-
-			By("Cleanup the specific resource instance WorkloadIdentity")
-			Expect(k8sClient.Delete(ctx, resource)).To(Succeed())
+			if err == nil {
+				By("Cleanup the specific resource instance WorkloadIdentity")
+				Expect(k8sClient.Delete(ctx, resource)).To(Succeed())
+				deleteWorkloadIdentity(ctx, typeNamespacedName)
+			} else {
+				Expect(errors.IsNotFound(err)).To(BeTrue())
+			}
 
 			deps := &appsv1.DeploymentList{}
 			label, err := labels.Parse("app=test-app")
@@ -185,19 +212,21 @@ var _ = Describe("WorkloadIdentity Controller", func() {
 				Expect(cm.Data).NotTo(BeNil())
 
 				actual := make(map[string]interface{})
-				fmt.Println(cm.Data[GCP_CONFIGURATION_FILE_NAME])
-				err = json.Unmarshal([]byte(cm.Data[GCP_CONFIGURATION_FILE_NAME]), &actual)
-				Expect(err).NotTo(HaveOccurred())
-				expected := make(map[string]interface{})
-				err = json.Unmarshal([]byte(fmt.Sprintf(GCP_CONF_BASE,
-					sampleProvider.Spec.Project.Number,
-					sampleProvider.Spec.Location,
-					sampleProvider.Spec.PoolID,
-					sampleProvider.Spec.ProviderID,
-					GCP_TOKEN_MOUNT_PATH+GCP_TOKEN_PATH,
-					workloadidentity.Spec.TargetServiceAccount,
-				)), &expected)
+				fmt.Println(cm.Data[backend.GCP_CONFIGURATION_FILE_NAME])
+				err = json.Unmarshal([]byte(cm.Data[backend.GCP_CONFIGURATION_FILE_NAME]), &actual)
 				Expect(err).NotTo(HaveOccurred())
+				expected := map[string]interface{}{
+					"universe_domain":    backend.GCP_UNIVERSE_DOMAIN,
+					"type":               backend.GCP_EXTERNAL_ACCOUNT_TYPE,
+					"audience":           fmt.Sprintf(backend.GCP_AUDIENCE_BASE, backend.GCP_UNIVERSE_DOMAIN, sampleProvider.Spec.Project.Number, sampleProvider.Spec.Location, sampleProvider.Spec.PoolID, sampleProvider.Spec.ProviderID),
+					"subject_token_type": backend.GCP_SUBJECT_TOKEN_TYPE_JWT,
+					"token_url":          fmt.Sprintf(backend.GCP_TOKEN_URL_BASE, backend.GCP_UNIVERSE_DOMAIN),
+					"credential_source": map[string]interface{}{
+						"file":   backend.GCP_TOKEN_MOUNT_PATH + backend.GCP_TOKEN_PATH,
+						"format": map[string]interface{}{"type": "text"},
+					},
+					"service_account_impersonation_url": fmt.Sprintf(backend.GCP_SERVICE_ACCOUNT_IMPERSONATION_BASE, "iamcredentials."+backend.GCP_UNIVERSE_DOMAIN, workloadidentity.Spec.TargetServiceAccount),
+				}
 				Expect(actual).To(Equal(expected))
 			}
 			{
@@ -207,15 +236,15 @@ var _ = Describe("WorkloadIdentity Controller", func() {
 				Expect(err).NotTo(HaveOccurred())
 				for _, container := range dep.Spec.Template.Spec.Containers {
 					Expect(container.Env).To(ContainElement(corev1.EnvVar{
-						Name:  GOOGLE_CREDENTIALS_ENV,
-						Value: GCP_CONFIGURATION_MOUNT_PATH + GCP_CONFIGURATION_FILE_NAME,
+						Name:  backend.GOOGLE_CREDENTIALS_ENV,
+						Value: backend.GCP_CONFIGURATION_MOUNT_PATH + backend.GCP_CONFIGURATION_FILE_NAME,
 					}))
 					containsTokenVolume := false
 					for _, volume := range dep.Spec.Template.Spec.Volumes {
-						if volume.Name == GCP_TOKEN_VOLUME_NAME {
+						if volume.Name == backend.GCP_TOKEN_VOLUME_NAME {
 							containsTokenVolume = true
 						}
-						if volume.Name == GCP_CONFIGURATION_FILE_NAME {
+						if volume.Name == backend.GCP_CONFIGURATION_FILE_NAME {
 							Expect(volume.ConfigMap.Name).To(Equal(configMapName(workloadidentity)))
 						}
 					}
@@ -223,5 +252,908 @@ var _ = Describe("WorkloadIdentity Controller", func() {
 				}
 			}
 		})
+
+		It("should report an invalid spec without requeueing", func() {
+			workloadidentity := &k8sv1alpha1.WorkloadIdentity{}
+			err := k8sClient.Get(ctx, typeNamespacedName, workloadidentity)
+			Expect(err).NotTo(HaveOccurred())
+			workloadidentity.Spec.TargetServiceAccount = ""
+			Expect(k8sClient.Update(ctx, workloadidentity)).To(Succeed())
+			controllerReconciler := &WorkloadIdentityReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			err = k8sClient.Get(ctx, typeNamespacedName, workloadidentity)
+			Expect(err).NotTo(HaveOccurred())
+			fail := meta.FindStatusCondition(workloadidentity.Status.Conditions, k8sv1alpha1.TypeWorkloadIdentityFail)
+			Expect(fail).NotTo(BeNil())
+			Expect(fail.Status).To(Equal(metav1.ConditionTrue))
+			Expect(fail.Reason).To(Equal("InvalidSpec"))
+			Expect(meta.IsStatusConditionFalse(workloadidentity.Status.Conditions, k8sv1alpha1.TypeWorkloadIdentityDone)).To(BeTrue())
+		})
+
+		It("should not report Done while the claims are rejected", func() {
+			controllerReconciler := &WorkloadIdentityReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+			Expect(k8sClient.Create(ctx,
+				sampleDeployment(targetNamespacedName.Name, targetNamespacedName.Namespace),
+			)).To(Succeed())
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			workloadidentity := &k8sv1alpha1.WorkloadIdentity{}
+			err = k8sClient.Get(ctx, typeNamespacedName, workloadidentity)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(meta.IsStatusConditionTrue(workloadidentity.Status.Conditions, k8sv1alpha1.TypeWorkloadIdentityDone)).To(BeTrue())
+
+			By("Restricting the Provider to another namespace")
+			provider := &k8sv1alpha1.Provider{}
+			err = k8sClient.Get(ctx, client.ObjectKeyFromObject(&sampleProvider), provider)
+			Expect(err).NotTo(HaveOccurred())
+			provider.Spec.AttributeMapping = map[string]string{
+				"google.subject":      "assertion.sub",
+				"attribute.namespace": `assertion["kubernetes.io"]["namespace"]`,
+			}
+			provider.Spec.AttributeCondition = `attribute.namespace == "other"`
+			Expect(k8sClient.Update(ctx, provider)).To(Succeed())
+			DeferCleanup(func() {
+				err := k8sClient.Get(ctx, client.ObjectKeyFromObject(&sampleProvider), provider)
+				Expect(err).NotTo(HaveOccurred())
+				provider.Spec.AttributeMapping = nil
+				provider.Spec.AttributeCondition = ""
+				Expect(k8sClient.Update(ctx, provider)).To(Succeed())
+			})
+
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			err = k8sClient.Get(ctx, typeNamespacedName, workloadidentity)
+			Expect(err).NotTo(HaveOccurred())
+			fail := meta.FindStatusCondition(workloadidentity.Status.Conditions, k8sv1alpha1.TypeWorkloadIdentityFail)
+			Expect(fail).NotTo(BeNil())
+			Expect(fail.Status).To(Equal(metav1.ConditionTrue))
+			Expect(fail.Reason).To(Equal("ClaimsRejected"))
+			done := meta.FindStatusCondition(workloadidentity.Status.Conditions, k8sv1alpha1.TypeWorkloadIdentityDone)
+			Expect(done).NotTo(BeNil())
+			Expect(done.Status).To(Equal(metav1.ConditionFalse))
+			Expect(done.Reason).To(Equal("ClaimsRejected"))
+		})
+
+		It("should clean up the injected credentials on deletion", func() {
+			controllerReconciler := &WorkloadIdentityReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+			Expect(k8sClient.Create(ctx,
+				sampleDeployment(targetNamespacedName.Name, targetNamespacedName.Namespace),
+			)).To(Succeed())
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			workloadidentity := &k8sv1alpha1.WorkloadIdentity{}
+			err = k8sClient.Get(ctx, typeNamespacedName, workloadidentity)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(workloadidentity.Finalizers).To(ContainElement(FINALIZER))
+
+			By("Checking the ConfigMap is owned by the WorkloadIdentity")
+			cm := &corev1.ConfigMap{}
+			err = k8sClient.Get(ctx, types.NamespacedName{
+				Name:      configMapName(workloadidentity),
+				Namespace: workloadidentity.Namespace,
+			}, cm)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(metav1.IsControlledBy(cm, workloadidentity)).To(BeTrue())
+
+			By("Deleting the WorkloadIdentity")
+			Expect(k8sClient.Delete(ctx, workloadidentity)).To(Succeed())
+			deleteWorkloadIdentity(ctx, typeNamespacedName)
+
+			By("Checking the ConfigMap is deleted")
+			err = k8sClient.Get(ctx, client.ObjectKeyFromObject(cm), cm)
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+
+			By("Checking the Deployment is released")
+			dep := &appsv1.Deployment{}
+			err = k8sClient.Get(ctx, targetNamespacedName, dep)
+			Expect(err).NotTo(HaveOccurred())
+			for _, container := range dep.Spec.Template.Spec.Containers {
+				for _, env := range container.Env {
+					Expect(env.Name).NotTo(Equal(backend.GOOGLE_CREDENTIALS_ENV))
+				}
+			}
+			for _, volume := range dep.Spec.Template.Spec.Volumes {
+				Expect(volume.Name).NotTo(Equal(backend.GCP_TOKEN_VOLUME_NAME))
+			}
+		})
+
+		It("should finalize without requesting cert-manager when no Certificate was created", func() {
+			_, err := k8sClient.RESTMapper().RESTMapping(CERTIFICATE_GVK.GroupKind(), CERTIFICATE_GVK.Version)
+			Expect(meta.IsNoMatchError(err)).To(BeTrue())
+
+			Expect(k8sClient.Create(ctx,
+				sampleDeployment(targetNamespacedName.Name, targetNamespacedName.Namespace),
+			)).To(Succeed())
+			controllerReconciler := &WorkloadIdentityReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			workloadidentity := &k8sv1alpha1.WorkloadIdentity{}
+			err = k8sClient.Get(ctx, typeNamespacedName, workloadidentity)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(workloadidentity.Status.Certificate).To(BeEmpty())
+			Expect(k8sClient.Delete(ctx, workloadidentity)).To(Succeed())
+
+			By("Finalizing with a client recording cert-manager requests")
+			withWatch, err := client.NewWithWatch(cfg, client.Options{Scheme: k8sClient.Scheme()})
+			Expect(err).NotTo(HaveOccurred())
+			certManagerRequests := 0
+			recordCertManager := func(obj client.Object) {
+				if obj.GetObjectKind().GroupVersionKind().Group == CERTIFICATE_GVK.Group {
+					certManagerRequests++
+				}
+			}
+			controllerReconciler.Client = interceptor.NewClient(withWatch, interceptor.Funcs{
+				Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+					recordCertManager(obj)
+					return c.Get(ctx, key, obj, opts...)
+				},
+				Delete: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.DeleteOption) error {
+					recordCertManager(obj)
+					return c.Delete(ctx, obj, opts...)
+				},
+			})
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(certManagerRequests).To(BeZero())
+			err = k8sClient.Get(ctx, typeNamespacedName, workloadidentity)
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+		})
+
+		It("should leave the Deployment untouched on a second reconcile with auto rollout disabled", func() {
+			workloadidentity := &k8sv1alpha1.WorkloadIdentity{}
+			err := k8sClient.Get(ctx, typeNamespacedName, workloadidentity)
+			Expect(err).NotTo(HaveOccurred())
+			workloadidentity.Spec.DisableAutoRollout = true
+			Expect(k8sClient.Update(ctx, workloadidentity)).To(Succeed())
+			Expect(k8sClient.Create(ctx,
+				sampleDeployment(targetNamespacedName.Name, targetNamespacedName.Namespace),
+			)).To(Succeed())
+
+			withWatch, err := client.NewWithWatch(cfg, client.Options{Scheme: k8sClient.Scheme()})
+			Expect(err).NotTo(HaveOccurred())
+			deploymentPatches := 0
+			controllerReconciler := &WorkloadIdentityReconciler{
+				Client: interceptor.NewClient(withWatch, interceptor.Funcs{
+					Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+						if obj.GetObjectKind().GroupVersionKind().Kind == "Deployment" {
+							deploymentPatches++
+						}
+						return c.Patch(ctx, obj, patch, opts...)
+					},
+				}),
+				Scheme: k8sClient.Scheme(),
+			}
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(deploymentPatches).To(Equal(1))
+
+			dep := &appsv1.Deployment{}
+			err = k8sClient.Get(ctx, targetNamespacedName, dep)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dep.Spec.Template.Annotations).NotTo(HaveKey(CONFIG_HASH_ANNOTATION_PREFIX + resourceName))
+
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(deploymentPatches).To(Equal(1))
+		})
+
+		It("should propagate changes of the Provider", func() {
+			controllerReconciler := &WorkloadIdentityReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+			Expect(k8sClient.Create(ctx,
+				sampleDeployment(targetNamespacedName.Name, targetNamespacedName.Namespace),
+			)).To(Succeed())
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			dep := &appsv1.Deployment{}
+			err = k8sClient.Get(ctx, targetNamespacedName, dep)
+			Expect(err).NotTo(HaveOccurred())
+			previousHash := dep.Spec.Template.Annotations[CONFIG_HASH_ANNOTATION_PREFIX+resourceName]
+			Expect(previousHash).NotTo(BeEmpty())
+
+			By("Updating the Provider")
+			provider := &k8sv1alpha1.Provider{}
+			err = k8sClient.Get(ctx, client.ObjectKeyFromObject(&sampleProvider), provider)
+			Expect(err).NotTo(HaveOccurred())
+			provider.Spec.PoolID = "updated-pool-id"
+			Expect(k8sClient.Update(ctx, provider)).To(Succeed())
+			DeferCleanup(func() {
+				err := k8sClient.Get(ctx, client.ObjectKeyFromObject(&sampleProvider), provider)
+				Expect(err).NotTo(HaveOccurred())
+				provider.Spec.PoolID = sampleProvider.Spec.PoolID
+				Expect(k8sClient.Update(ctx, provider)).To(Succeed())
+			})
+
+			indexedReconciler := &WorkloadIdentityReconciler{
+				Client: indexedClient,
+				Scheme: indexedClient.Scheme(),
+			}
+			Eventually(func() []reconcile.Request {
+				return indexedReconciler.workloadIdentitiesForProvider(ctx, provider)
+			}).Should(ContainElement(reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			}))
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			workloadidentity := &k8sv1alpha1.WorkloadIdentity{}
+			err = k8sClient.Get(ctx, typeNamespacedName, workloadidentity)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(workloadidentity.Status.ProviderGeneration).To(Equal(provider.Generation))
+
+			By("Checking the ConfigMap is rendered again")
+			cm := &corev1.ConfigMap{}
+			err = k8sClient.Get(ctx, types.NamespacedName{
+				Name:      configMapName(workloadidentity),
+				Namespace: workloadidentity.Namespace,
+			}, cm)
+			Expect(err).NotTo(HaveOccurred())
+			actual := make(map[string]interface{})
+			err = json.Unmarshal([]byte(cm.Data[backend.GCP_CONFIGURATION_FILE_NAME]), &actual)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(actual["audience"]).To(Equal(fmt.Sprintf(backend.GCP_AUDIENCE_BASE, backend.GCP_UNIVERSE_DOMAIN,
+				provider.Spec.Project.Number, provider.Spec.Location, provider.Spec.PoolID, provider.Spec.ProviderID)))
+
+			By("Checking the token audience on the Deployment")
+			err = k8sClient.Get(ctx, targetNamespacedName, dep)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dep.Spec.Template.Annotations[CONFIG_HASH_ANNOTATION_PREFIX+resourceName]).NotTo(Equal(previousHash))
+			var tokenVolume *corev1.Volume
+			for _, volume := range dep.Spec.Template.Spec.Volumes {
+				if volume.Name == backend.GCP_TOKEN_VOLUME_NAME {
+					tokenVolume = &volume
+				}
+			}
+			Expect(tokenVolume).NotTo(BeNil())
+			Expect(tokenVolume.Projected.Sources[0].ServiceAccountToken.Audience).To(ContainSubstring(provider.Spec.PoolID))
+		})
+
+		It("should clean up the previous deployment when retargeted", func() {
+			controllerReconciler := &WorkloadIdentityReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+			retargetedNamespacedName := types.NamespacedName{
+				Name:      "retargeted-deployment",
+				Namespace: targetNamespacedName.Namespace,
+			}
+			Expect(k8sClient.Create(ctx,
+				sampleDeployment(targetNamespacedName.Name, targetNamespacedName.Namespace),
+			)).To(Succeed())
+			Expect(k8sClient.Create(ctx,
+				sampleDeployment(retargetedNamespacedName.Name, retargetedNamespacedName.Namespace),
+			)).To(Succeed())
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			workloadidentity := &k8sv1alpha1.WorkloadIdentity{}
+			err = k8sClient.Get(ctx, typeNamespacedName, workloadidentity)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(workloadidentity.Status.AppliedDeployment).To(Equal(targetNamespacedName.Name))
+			previousConfigMapName := configMapName(workloadidentity)
+
+			By("Retargeting the WorkloadIdentity")
+			workloadidentity.Spec.Deployment = retargetedNamespacedName.Name
+			Expect(k8sClient.Update(ctx, workloadidentity)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			err = k8sClient.Get(ctx, typeNamespacedName, workloadidentity)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(workloadidentity.Status.AppliedDeployment).To(Equal(retargetedNamespacedName.Name))
+
+			By("Checking the previous ConfigMap is deleted")
+			err = k8sClient.Get(ctx, types.NamespacedName{
+				Name:      previousConfigMapName,
+				Namespace: workloadidentity.Namespace,
+			}, &corev1.ConfigMap{})
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+
+			By("Checking the previous Deployment is released")
+			dep := &appsv1.Deployment{}
+			err = k8sClient.Get(ctx, targetNamespacedName, dep)
+			Expect(err).NotTo(HaveOccurred())
+			for _, container := range dep.Spec.Template.Spec.Containers {
+				for _, env := range container.Env {
+					Expect(env.Name).NotTo(Equal(backend.GOOGLE_CREDENTIALS_ENV))
+				}
+			}
+
+			By("Checking the new Deployment has the credentials")
+			err = k8sClient.Get(ctx, retargetedNamespacedName, dep)
+			Expect(err).NotTo(HaveOccurred())
+			for _, container := range dep.Spec.Template.Spec.Containers {
+				Expect(container.Env).To(ContainElement(corev1.EnvVar{
+					Name:  backend.GOOGLE_CREDENTIALS_ENV,
+					Value: backend.GCP_CONFIGURATION_MOUNT_PATH + backend.GCP_CONFIGURATION_FILE_NAME,
+				}))
+			}
+		})
+
+		It("should clear the blocked cleanup once the previous deployment is released", func() {
+			Expect(k8sClient.Create(ctx,
+				sampleDeployment(targetNamespacedName.Name, targetNamespacedName.Namespace),
+			)).To(Succeed())
+			controllerReconciler := &WorkloadIdentityReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			By("Retargeting to a deployment not created yet while ConfigMaps cannot be deleted")
+			workloadidentity := &k8sv1alpha1.WorkloadIdentity{}
+			err = k8sClient.Get(ctx, typeNamespacedName, workloadidentity)
+			Expect(err).NotTo(HaveOccurred())
+			workloadidentity.Spec.Deployment = "missing-deployment"
+			Expect(k8sClient.Update(ctx, workloadidentity)).To(Succeed())
+			withWatch, err := client.NewWithWatch(cfg, client.Options{Scheme: k8sClient.Scheme()})
+			Expect(err).NotTo(HaveOccurred())
+			blockedReconciler := &WorkloadIdentityReconciler{
+				Client: interceptor.NewClient(withWatch, interceptor.Funcs{
+					Delete: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.DeleteOption) error {
+						if _, ok := obj.(*corev1.ConfigMap); ok {
+							return errors.NewForbidden(corev1.Resource("configmaps"), obj.GetName(), nil)
+						}
+						return c.Delete(ctx, obj, opts...)
+					},
+				}),
+				Scheme: k8sClient.Scheme(),
+			}
+			_, err = blockedReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).To(HaveOccurred())
+			err = k8sClient.Get(ctx, typeNamespacedName, workloadidentity)
+			Expect(err).NotTo(HaveOccurred())
+			fail := meta.FindStatusCondition(workloadidentity.Status.Conditions, k8sv1alpha1.TypeWorkloadIdentityFail)
+			Expect(fail).NotTo(BeNil())
+			Expect(fail.Reason).To(Equal("CleanupBlocked"))
+
+			By("Reconciling once the ConfigMap can be deleted")
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			err = k8sClient.Get(ctx, typeNamespacedName, workloadidentity)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(workloadidentity.Status.AppliedDeployment).To(BeEmpty())
+			fail = meta.FindStatusCondition(workloadidentity.Status.Conditions, k8sv1alpha1.TypeWorkloadIdentityFail)
+			Expect(fail).NotTo(BeNil())
+			Expect(fail.Status).To(Equal(metav1.ConditionFalse))
+		})
+	})
+
+	Context("When reconciling a resource with an aws provider", func() {
+		const resourceName = "test-aws-resource"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{
+			Name:      resourceName,
+			Namespace: "default",
+		}
+		targetNamespacedName := types.NamespacedName{
+			Name:      "target-aws-deployment",
+			Namespace: typeNamespacedName.Namespace,
+		}
+
+		BeforeEach(func() {
+			By("creating the custom resource for the Kind WorkloadIdentity")
+			workloadidentity := &k8sv1alpha1.WorkloadIdentity{}
+			err := k8sClient.Get(ctx, typeNamespacedName, workloadidentity)
+			if err != nil && errors.IsNotFound(err) {
+				resource := &k8sv1alpha1.WorkloadIdentity{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      resourceName,
+						Namespace: typeNamespacedName.Namespace,
+					},
+					Spec: k8sv1alpha1.WorkloadIdentitySpec{
+						Provider: k8sv1alpha1.WorkloadIdentityProvider{
+							Name:      sampleAWSProvider.Name,
+							Namespace: "default",
+						},
+						Deployment: targetNamespacedName.Name,
+					},
+				}
+				Expect(k8sClient.Create(ctx, resource)).To(Succeed())
+			}
+			err = k8sClient.Get(ctx, client.ObjectKeyFromObject(&sampleAWSProvider), &sampleAWSProvider)
+			if err != nil && errors.IsNotFound(err) {
+				Expect(k8sClient.Create(ctx, &sampleAWSProvider)).To(Succeed())
+			}
+		})
+
+		AfterEach(func() {
+			resource := &k8sv1alpha1.WorkloadIdentity{}
+			err := k8sClient.Get(ctx, typeNamespacedName, resource)
+			if err == nil {
+				By("Cleanup the specific resource instance WorkloadIdentity")
+				Expect(k8sClient.Delete(ctx, resource)).To(Succeed())
+				deleteWorkloadIdentity(ctx, typeNamespacedName)
+			} else {
+				Expect(errors.IsNotFound(err)).To(BeTrue())
+			}
+
+			dep := &appsv1.Deployment{}
+			err = k8sClient.Get(ctx, targetNamespacedName, dep)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Delete(ctx, dep)).To(Succeed())
+		})
+
+		It("should inject the AWS web identity environment", func() {
+			controllerReconciler := &WorkloadIdentityReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+			Expect(k8sClient.Create(ctx,
+				sampleDeployment(targetNamespacedName.Name, targetNamespacedName.Namespace),
+			)).To(Succeed())
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			By("Checking the Deployment")
+			dep := &appsv1.Deployment{}
+			err = k8sClient.Get(ctx, targetNamespacedName, dep)
+			Expect(err).NotTo(HaveOccurred())
+			for _, container := range dep.Spec.Template.Spec.Containers {
+				Expect(container.Env).To(ContainElements(
+					corev1.EnvVar{Name: backend.AWS_ROLE_ARN_ENV, Value: sampleAWSProvider.Spec.AWS.RoleARN},
+					corev1.EnvVar{Name: backend.AWS_WEB_IDENTITY_TOKEN_FILE_ENV, Value: backend.AWS_TOKEN_MOUNT_PATH + backend.AWS_TOKEN_PATH},
+					corev1.EnvVar{Name: backend.AWS_REGION_ENV, Value: sampleAWSProvider.Spec.AWS.Region},
+					corev1.EnvVar{Name: backend.AWS_STS_REGIONAL_ENDPOINTS_ENV, Value: "regional"},
+				))
+			}
+			var tokenVolume *corev1.Volume
+			for _, volume := range dep.Spec.Template.Spec.Volumes {
+				if volume.Name == backend.AWS_TOKEN_VOLUME_NAME {
+					tokenVolume = &volume
+				}
+			}
+			Expect(tokenVolume).NotTo(BeNil())
+			Expect(tokenVolume.Projected.Sources[0].ServiceAccountToken.Audience).To(Equal(backend.AWS_TOKEN_AUDIENCE))
+		})
+
+		It("should not leave a ConfigMap when no files are rendered", func() {
+			controllerReconciler := &WorkloadIdentityReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+			Expect(k8sClient.Create(ctx,
+				sampleDeployment(targetNamespacedName.Name, targetNamespacedName.Namespace),
+			)).To(Succeed())
+			workloadidentity := &k8sv1alpha1.WorkloadIdentity{}
+			err := k8sClient.Get(ctx, typeNamespacedName, workloadidentity)
+			Expect(err).NotTo(HaveOccurred())
+			cmKey := types.NamespacedName{
+				Name:      configMapName(workloadidentity),
+				Namespace: workloadidentity.Namespace,
+			}
+
+			By("Rendering the shared config of a profile")
+			workloadidentity.Spec.AWS = &k8sv1alpha1.WorkloadIdentityAWS{
+				Profiles: []k8sv1alpha1.AWSProfile{
+					{Name: "reader", RoleARN: "arn:aws:iam::123456789012:role/reader"},
+				},
+			}
+			Expect(k8sClient.Update(ctx, workloadidentity)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, cmKey, &corev1.ConfigMap{})).To(Succeed())
+
+			By("Dropping the profiles")
+			err = k8sClient.Get(ctx, typeNamespacedName, workloadidentity)
+			Expect(err).NotTo(HaveOccurred())
+			workloadidentity.Spec.AWS = nil
+			Expect(k8sClient.Update(ctx, workloadidentity)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			err = k8sClient.Get(ctx, cmKey, &corev1.ConfigMap{})
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+
+			dep := &appsv1.Deployment{}
+			err = k8sClient.Get(ctx, targetNamespacedName, dep)
+			Expect(err).NotTo(HaveOccurred())
+			for _, volume := range dep.Spec.Template.Spec.Volumes {
+				Expect(volume.ConfigMap).To(BeNil())
+			}
+			err = k8sClient.Get(ctx, typeNamespacedName, workloadidentity)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(meta.IsStatusConditionTrue(workloadidentity.Status.Conditions, k8sv1alpha1.TypeWorkloadIdentityDone)).To(BeTrue())
+		})
+	})
+
+	Context("When reconciling resources sharing a ServiceAccount", func() {
+		ctx := context.Background()
+
+		const serviceAccount = "shared-sa"
+		namespace := "default"
+		gkeProvider := &k8sv1alpha1.Provider{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "test-gke-provider",
+				Namespace: namespace,
+			},
+			Spec: k8sv1alpha1.ProviderSpec{
+				Target: k8sv1alpha1.ProviderTargetTypeGKENative,
+			},
+		}
+		newWorkloadIdentity := func(name, deployment string) *k8sv1alpha1.WorkloadIdentity {
+			return &k8sv1alpha1.WorkloadIdentity{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+				Spec: k8sv1alpha1.WorkloadIdentitySpec{
+					Provider: k8sv1alpha1.WorkloadIdentityProvider{
+						Name:      gkeProvider.Name,
+						Namespace: namespace,
+					},
+					TargetServiceAccount: name + "@project.iam.gserviceaccount.com",
+					Deployment:           deployment,
+				},
+			}
+		}
+		reconcileWorkloadIdentity := func(name string) *k8sv1alpha1.WorkloadIdentity {
+			controllerReconciler := &WorkloadIdentityReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+			key := types.NamespacedName{Name: name, Namespace: namespace}
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: key})
+			Expect(err).NotTo(HaveOccurred())
+			wi := &k8sv1alpha1.WorkloadIdentity{}
+			Expect(k8sClient.Get(ctx, key, wi)).To(Succeed())
+			return wi
+		}
+		serviceAccountAnnotation := func() string {
+			sa := &corev1.ServiceAccount{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: serviceAccount, Namespace: namespace}, sa)).To(Succeed())
+			return sa.Annotations[backend.GKE_SERVICE_ACCOUNT_ANNOTATION]
+		}
+
+		BeforeEach(func() {
+			Expect(k8sClient.Create(ctx, gkeProvider.DeepCopy())).To(Succeed())
+			Expect(k8sClient.Create(ctx, &corev1.ServiceAccount{
+				ObjectMeta: metav1.ObjectMeta{Name: serviceAccount, Namespace: namespace},
+			})).To(Succeed())
+			for _, name := range []string{"shared-deployment-a", "shared-deployment-b"} {
+				dep := sampleDeployment(name, namespace)
+				dep.Spec.Template.Spec.ServiceAccountName = serviceAccount
+				Expect(k8sClient.Create(ctx, dep)).To(Succeed())
+			}
+			Expect(k8sClient.Create(ctx, newWorkloadIdentity("shared-a", "shared-deployment-a"))).To(Succeed())
+			Expect(k8sClient.Create(ctx, newWorkloadIdentity("shared-b", "shared-deployment-b"))).To(Succeed())
+		})
+
+		AfterEach(func() {
+			for _, name := range []string{"shared-a", "shared-b"} {
+				key := types.NamespacedName{Name: name, Namespace: namespace}
+				wi := &k8sv1alpha1.WorkloadIdentity{}
+				err := k8sClient.Get(ctx, key, wi)
+				if errors.IsNotFound(err) {
+					continue
+				}
+				Expect(err).NotTo(HaveOccurred())
+				Expect(k8sClient.Delete(ctx, wi)).To(Succeed())
+				deleteWorkloadIdentity(ctx, key)
+			}
+			for _, name := range []string{"shared-deployment-a", "shared-deployment-b"} {
+				Expect(k8sClient.Delete(ctx, sampleDeployment(name, namespace))).To(Succeed())
+			}
+			Expect(k8sClient.Delete(ctx, &corev1.ServiceAccount{
+				ObjectMeta: metav1.ObjectMeta{Name: serviceAccount, Namespace: namespace},
+			})).To(Succeed())
+			Expect(k8sClient.Delete(ctx, gkeProvider.DeepCopy())).To(Succeed())
+		})
+
+		It("should annotate the ServiceAccount for one WorkloadIdentity only", func() {
+			a := reconcileWorkloadIdentity("shared-a")
+			Expect(a.Status.ServiceAccount).To(Equal(serviceAccount))
+			Expect(serviceAccountAnnotation()).To(Equal(a.Spec.TargetServiceAccount))
+
+			By("Reconciling another WorkloadIdentity using the ServiceAccount")
+			b := reconcileWorkloadIdentity("shared-b")
+			Expect(b.Status.ServiceAccount).To(BeEmpty())
+			fail := meta.FindStatusCondition(b.Status.Conditions, k8sv1alpha1.TypeWorkloadIdentityFail)
+			Expect(fail).NotTo(BeNil())
+			Expect(fail.Reason).To(Equal("ServiceAccountShared"))
+			Expect(serviceAccountAnnotation()).To(Equal(a.Spec.TargetServiceAccount))
+
+			By("Deleting the WorkloadIdentity refused the ServiceAccount")
+			Expect(k8sClient.Delete(ctx, b)).To(Succeed())
+			deleteWorkloadIdentity(ctx, client.ObjectKeyFromObject(b))
+			Expect(serviceAccountAnnotation()).To(Equal(a.Spec.TargetServiceAccount))
+
+			By("Deleting the WorkloadIdentity managing the ServiceAccount")
+			Expect(k8sClient.Delete(ctx, a)).To(Succeed())
+			deleteWorkloadIdentity(ctx, client.ObjectKeyFromObject(a))
+			Expect(serviceAccountAnnotation()).To(BeEmpty())
+		})
+
+		It("should clear the failure once the ServiceAccount is no longer shared", func() {
+			a := reconcileWorkloadIdentity("shared-a")
+			Expect(a.Status.ServiceAccount).To(Equal(serviceAccount))
+			b := reconcileWorkloadIdentity("shared-b")
+			Expect(meta.IsStatusConditionTrue(b.Status.Conditions, k8sv1alpha1.TypeWorkloadIdentityFail)).To(BeTrue())
+
+			By("Deleting the WorkloadIdentity managing the ServiceAccount")
+			Expect(k8sClient.Delete(ctx, a)).To(Succeed())
+			deleteWorkloadIdentity(ctx, client.ObjectKeyFromObject(a))
+
+			b = reconcileWorkloadIdentity("shared-b")
+			Expect(b.Status.ServiceAccount).To(Equal(serviceAccount))
+			Expect(serviceAccountAnnotation()).To(Equal(b.Spec.TargetServiceAccount))
+			fail := meta.FindStatusCondition(b.Status.Conditions, k8sv1alpha1.TypeWorkloadIdentityFail)
+			Expect(fail).NotTo(BeNil())
+			Expect(fail.Status).To(Equal(metav1.ConditionFalse))
+			Expect(meta.IsStatusConditionTrue(b.Status.Conditions, k8sv1alpha1.TypeWorkloadIdentityDone)).To(BeTrue())
+		})
+
+		It("should release the ServiceAccount when switching to another target", func() {
+			a := reconcileWorkloadIdentity("shared-a")
+			Expect(serviceAccountAnnotation()).To(Equal(a.Spec.TargetServiceAccount))
+
+			By("Switching to the gcp provider")
+			err := k8sClient.Get(ctx, client.ObjectKeyFromObject(&sampleProvider), &sampleProvider)
+			if err != nil && errors.IsNotFound(err) {
+				Expect(k8sClient.Create(ctx, &sampleProvider)).To(Succeed())
+			}
+			a.Spec.Provider.Name = sampleProvider.Name
+			Expect(k8sClient.Update(ctx, a)).To(Succeed())
+			a = reconcileWorkloadIdentity("shared-a")
+			Expect(a.Status.ServiceAccount).To(BeEmpty())
+			Expect(serviceAccountAnnotation()).To(BeEmpty())
+
+			By("Letting another WorkloadIdentity manage the ServiceAccount")
+			b := reconcileWorkloadIdentity("shared-b")
+			Expect(b.Status.ServiceAccount).To(Equal(serviceAccount))
+			Expect(serviceAccountAnnotation()).To(Equal(b.Spec.TargetServiceAccount))
+		})
+	})
+
+	Context("When reconciling resources sharing a Deployment", func() {
+		ctx := context.Background()
+
+		const deployment = "shared-deployment"
+		namespace := "default"
+		gcpName := types.NamespacedName{Name: "shared-gcp", Namespace: namespace}
+		awsName := types.NamespacedName{Name: "shared-aws", Namespace: namespace}
+		newWorkloadIdentity := func(name types.NamespacedName, provider string) *k8sv1alpha1.WorkloadIdentity {
+			return &k8sv1alpha1.WorkloadIdentity{
+				ObjectMeta: metav1.ObjectMeta{Name: name.Name, Namespace: name.Namespace},
+				Spec: k8sv1alpha1.WorkloadIdentitySpec{
+					Provider: k8sv1alpha1.WorkloadIdentityProvider{
+						Name:      provider,
+						Namespace: namespace,
+					},
+					TargetServiceAccount: "test-service-account",
+					Deployment:           deployment,
+				},
+			}
+		}
+		envNames := func() []string {
+			dep := &appsv1.Deployment{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: deployment, Namespace: namespace}, dep)).To(Succeed())
+			names := []string{}
+			for _, env := range dep.Spec.Template.Spec.Containers[0].Env {
+				names = append(names, env.Name)
+			}
+			return names
+		}
+
+		BeforeEach(func() {
+			for _, provider := range []*k8sv1alpha1.Provider{&sampleProvider, &sampleAWSProvider} {
+				err := k8sClient.Get(ctx, client.ObjectKeyFromObject(provider), provider)
+				if err != nil && errors.IsNotFound(err) {
+					Expect(k8sClient.Create(ctx, provider)).To(Succeed())
+				}
+			}
+			Expect(k8sClient.Create(ctx, sampleDeployment(deployment, namespace))).To(Succeed())
+			Expect(k8sClient.Create(ctx, newWorkloadIdentity(gcpName, sampleProvider.Name))).To(Succeed())
+			Expect(k8sClient.Create(ctx, newWorkloadIdentity(awsName, sampleAWSProvider.Name))).To(Succeed())
+		})
+
+		AfterEach(func() {
+			for _, name := range []types.NamespacedName{gcpName, awsName} {
+				wi := &k8sv1alpha1.WorkloadIdentity{}
+				err := k8sClient.Get(ctx, name, wi)
+				if errors.IsNotFound(err) {
+					continue
+				}
+				Expect(err).NotTo(HaveOccurred())
+				Expect(k8sClient.Delete(ctx, wi)).To(Succeed())
+				deleteWorkloadIdentity(ctx, name)
+			}
+			Expect(k8sClient.Delete(ctx, sampleDeployment(deployment, namespace))).To(Succeed())
+		})
+
+		It("should keep the credentials of every WorkloadIdentity", func() {
+			withWatch, err := client.NewWithWatch(cfg, client.Options{Scheme: k8sClient.Scheme()})
+			Expect(err).NotTo(HaveOccurred())
+			deploymentPatches := 0
+			controllerReconciler := &WorkloadIdentityReconciler{
+				Client: interceptor.NewClient(withWatch, interceptor.Funcs{
+					Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+						if obj.GetObjectKind().GroupVersionKind().Kind == "Deployment" {
+							deploymentPatches++
+						}
+						return c.Patch(ctx, obj, patch, opts...)
+					},
+				}),
+				Scheme: k8sClient.Scheme(),
+			}
+			for _, name := range []types.NamespacedName{gcpName, awsName} {
+				_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: name})
+				Expect(err).NotTo(HaveOccurred())
+			}
+			Expect(deploymentPatches).To(Equal(2))
+			Expect(envNames()).To(ContainElements(backend.GOOGLE_CREDENTIALS_ENV, backend.AWS_ROLE_ARN_ENV))
+
+			By("Reconciling both WorkloadIdentities again")
+			for _, name := range []types.NamespacedName{gcpName, awsName} {
+				_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: name})
+				Expect(err).NotTo(HaveOccurred())
+			}
+			Expect(deploymentPatches).To(Equal(2))
+
+			By("Deleting the gcp WorkloadIdentity")
+			Expect(k8sClient.Delete(ctx, newWorkloadIdentity(gcpName, sampleProvider.Name))).To(Succeed())
+			deleteWorkloadIdentity(ctx, gcpName)
+			Expect(envNames()).NotTo(ContainElement(backend.GOOGLE_CREDENTIALS_ENV))
+			Expect(envNames()).To(ContainElement(backend.AWS_ROLE_ARN_ENV))
+		})
+
+		It("should release the fields applied by the shared field manager", func() {
+			By("Applying the gcp credentials as the shared field manager")
+			controllerReconciler := &WorkloadIdentityReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: gcpName})
+			Expect(err).NotTo(HaveOccurred())
+			dep := &appsv1.Deployment{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: deployment, Namespace: namespace}, dep)).To(Succeed())
+			wi := &k8sv1alpha1.WorkloadIdentity{}
+			Expect(k8sClient.Get(ctx, gcpName, wi)).To(Succeed())
+			legacy, err := appsv1apply.ExtractDeployment(dep, fieldManager(wi))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(controllerReconciler.apply(ctx, legacy, FIELD_MANAGER)).To(Succeed())
+
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: gcpName})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: deployment, Namespace: namespace}, dep)).To(Succeed())
+			Expect(managedBy(dep, FIELD_MANAGER)).To(BeFalse())
+			Expect(envNames()).To(ContainElement(backend.GOOGLE_CREDENTIALS_ENV))
+
+			By("Deleting the gcp WorkloadIdentity")
+			Expect(k8sClient.Delete(ctx, wi)).To(Succeed())
+			deleteWorkloadIdentity(ctx, gcpName)
+			Expect(envNames()).NotTo(ContainElement(backend.GOOGLE_CREDENTIALS_ENV))
+		})
+	})
+
+	Context("When mapping events to WorkloadIdentities", func() {
+		ctx := context.Background()
+
+		newWorkloadIdentity := func(name, namespace, deployment, provider string) *k8sv1alpha1.WorkloadIdentity {
+			return &k8sv1alpha1.WorkloadIdentity{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+				Spec: k8sv1alpha1.WorkloadIdentitySpec{
+					Deployment: deployment,
+					Provider: k8sv1alpha1.WorkloadIdentityProvider{
+						Name:      provider,
+						Namespace: "default",
+					},
+				},
+			}
+		}
+		var reconciler *WorkloadIdentityReconciler
+
+		BeforeEach(func() {
+			reconciler = &WorkloadIdentityReconciler{
+				Client: fake.NewClientBuilder().
+					WithScheme(scheme.Scheme).
+					WithObjects(
+						newWorkloadIdentity("wi-a", "default", "app", "gcp"),
+						newWorkloadIdentity("wi-b", "default", "other", "gcp"),
+						newWorkloadIdentity("wi-c", "team", "app", "aws"),
+						func() *k8sv1alpha1.WorkloadIdentity {
+							wi := newWorkloadIdentity("wi-d", "default", "another", "gcp")
+							wi.Spec.Provider.Namespace = ""
+							return wi
+						}(),
+					).
+					WithIndex(&k8sv1alpha1.WorkloadIdentity{}, DEPLOYMENT_INDEX, indexByDeployment).
+					WithIndex(&k8sv1alpha1.WorkloadIdentity{}, PROVIDER_INDEX, indexByProvider).
+					Build(),
+				Scheme: scheme.Scheme,
+			}
+		})
+
+		It("should enqueue the WorkloadIdentities targeting a Deployment", func() {
+			dep := sampleDeployment("app", "default")
+			Expect(reconciler.workloadIdentitiesForDeployment(ctx, dep)).To(ConsistOf(
+				reconcile.Request{NamespacedName: types.NamespacedName{Name: "wi-a", Namespace: "default"}},
+			))
+		})
+
+		It("should enqueue the WorkloadIdentities referring to a Provider", func() {
+			provider := &k8sv1alpha1.Provider{
+				ObjectMeta: metav1.ObjectMeta{Name: "gcp", Namespace: "default"},
+			}
+			Expect(reconciler.workloadIdentitiesForProvider(ctx, provider)).To(ConsistOf(
+				reconcile.Request{NamespacedName: types.NamespacedName{Name: "wi-a", Namespace: "default"}},
+				reconcile.Request{NamespacedName: types.NamespacedName{Name: "wi-b", Namespace: "default"}},
+				reconcile.Request{NamespacedName: types.NamespacedName{Name: "wi-d", Namespace: "default"}},
+			))
+		})
 	})
 })
+
+// deleteWorkloadIdentity runs the finalizer of a WorkloadIdentity that has
+// been marked for deletion and waits for it to be released.
+func deleteWorkloadIdentity(ctx context.Context, name types.NamespacedName) {
+	controllerReconciler := &WorkloadIdentityReconciler{
+		Client: k8sClient,
+		Scheme: k8sClient.Scheme(),
+	}
+	_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+		NamespacedName: name,
+	})
+	Expect(err).NotTo(HaveOccurred())
+	err = k8sClient.Get(ctx, name, &k8sv1alpha1.WorkloadIdentity{})
+	Expect(errors.IsNotFound(err)).To(BeTrue())
+}
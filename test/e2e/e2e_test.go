@@ -119,4 +119,60 @@ var _ = Describe("controller", Ordered, func() {
 
 		})
 	})
+
+	Context("MinIO target", func() {
+		const discoveryBinding = "kwimount-e2e-oidc-discovery"
+
+		BeforeAll(func() {
+			By("allowing MinIO to discover the service account issuer")
+			cmd := exec.Command("kubectl", "create", "clusterrolebinding", discoveryBinding,
+				"--clusterrole", "system:service-account-issuer-discovery",
+				"--group", "system:unauthenticated",
+			)
+			_, err := utils.Run(cmd)
+			Expect(err).NotTo(HaveOccurred())
+
+			By("deploying MinIO and the client")
+			Expect(kubectlApply(minioManifest)).To(Succeed())
+			cmd = exec.Command("kubectl", "rollout", "status", "deployment/minio",
+				"-n", minioNamespace, "--timeout", "5m")
+			_, err = utils.Run(cmd)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterAll(func() {
+			By("removing MinIO")
+			cmd := exec.Command("kubectl", "delete", "ns", minioNamespace)
+			_, _ = utils.Run(cmd)
+			cmd = exec.Command("kubectl", "delete", "clusterrolebinding", discoveryBinding)
+			_, _ = utils.Run(cmd)
+		})
+
+		It("should authenticate the AWS CLI to MinIO with the projected token", func() {
+			By("creating the bucket")
+			_, err := minioExec("mc mb local/" + minioBucket)
+			Expect(err).NotTo(HaveOccurred())
+
+			By("creating the WorkloadIdentity with the role of MinIO")
+			var roleARN string
+			Eventually(func() error {
+				roleARN, err = minioRoleARN()
+				return err
+			}, time.Minute, time.Second).Should(Succeed())
+			Expect(kubectlApply(fmt.Sprintf(minioWorkloadIdentityManifest, roleARN))).To(Succeed())
+
+			By("writing an object through AssumeRoleWithWebIdentity")
+			writeObject := func() error {
+				cmd := exec.Command("kubectl", "exec", "deployment/s3-client", "-n", minioNamespace, "--",
+					"aws", "s3", "cp", "/etc/hostname", "s3://"+minioBucket+"/hostname")
+				_, err := utils.Run(cmd)
+				return err
+			}
+			Eventually(writeObject, 3*time.Minute, 5*time.Second).Should(Succeed())
+
+			output, err := minioExec("mc ls local/" + minioBucket)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(output)).To(ContainSubstring("hostname"))
+		})
+	})
 })
@@ -0,0 +1,168 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package e2e
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/piny940/kwimount/test/utils"
+)
+
+const (
+	minioNamespace = "kwimount-e2e-minio"
+	minioAudience  = "kwimount-e2e"
+	minioBucket    = "kwimount-e2e"
+
+	// minioManifest runs a MinIO server trusting the service account issuer of
+	// the cluster through OpenID discovery, and an AWS CLI client for it.
+	minioManifest = `
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: ` + minioNamespace + `
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: minio
+  namespace: ` + minioNamespace + `
+spec:
+  selector:
+    matchLabels:
+      app: minio
+  template:
+    metadata:
+      labels:
+        app: minio
+    spec:
+      containers:
+      - name: minio
+        image: quay.io/minio/minio:latest
+        args: ["server", "/data", "--certs-dir", "/certs"]
+        env:
+        - name: MINIO_IDENTITY_OPENID_CONFIG_URL
+          value: https://kubernetes.default.svc/.well-known/openid-configuration
+        - name: MINIO_IDENTITY_OPENID_CLIENT_ID
+          value: ` + minioAudience + `
+        - name: MINIO_IDENTITY_OPENID_ROLE_POLICY
+          value: readwrite
+        ports:
+        - containerPort: 9000
+        volumeMounts:
+        - name: data
+          mountPath: /data
+        - name: cluster-ca
+          mountPath: /certs/CAs
+      volumes:
+      - name: data
+        emptyDir: {}
+      - name: cluster-ca
+        configMap:
+          name: kube-root-ca.crt
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: minio
+  namespace: ` + minioNamespace + `
+spec:
+  selector:
+    app: minio
+  ports:
+  - port: 9000
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: s3-client
+  namespace: ` + minioNamespace + `
+spec:
+  selector:
+    matchLabels:
+      app: s3-client
+  template:
+    metadata:
+      labels:
+        app: s3-client
+    spec:
+      containers:
+      - name: aws
+        image: amazon/aws-cli:latest
+        command: ["sleep", "infinity"]
+`
+
+	minioWorkloadIdentityManifest = `
+apiVersion: k8s.piny940.com/v1alpha1
+kind: Provider
+metadata:
+  name: minio
+  namespace: ` + minioNamespace + `
+spec:
+  target: minio
+  minio:
+    endpoint: http://minio.` + minioNamespace + `.svc:9000
+    roleARN: %s
+    audience: ` + minioAudience + `
+---
+apiVersion: k8s.piny940.com/v1alpha1
+kind: WorkloadIdentity
+metadata:
+  name: s3-client
+  namespace: ` + minioNamespace + `
+spec:
+  provider:
+    name: minio
+  deployment: s3-client
+`
+)
+
+// kubectlApply applies the manifest given on stdin.
+func kubectlApply(manifest string) error {
+	cmd := exec.Command("kubectl", "apply", "-f", "-")
+	cmd.Stdin = strings.NewReader(manifest)
+	_, err := utils.Run(cmd)
+	return err
+}
+
+// minioExec runs a shell script in the MinIO container with the mc alias
+// "local" pointing at the server as the root user.
+func minioExec(script string) ([]byte, error) {
+	cmd := exec.Command("kubectl", "exec", "deployment/minio", "-n", minioNamespace, "--",
+		"sh", "-c", "mc alias set local http://localhost:9000 minioadmin minioadmin >/dev/null && "+script)
+	return utils.Run(cmd)
+}
+
+// minioRoleARN returns the role ARN MinIO generated for its OpenID
+// configuration with a role policy.
+func minioRoleARN() (string, error) {
+	output, err := minioExec("mc idp openid ls local --json")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range utils.GetNonEmptyLines(string(output)) {
+		var item struct {
+			RoleARN string `json:"roleARN"`
+		}
+		if json.Unmarshal([]byte(line), &item) == nil && item.RoleARN != "" {
+			return item.RoleARN, nil
+		}
+	}
+	return "", fmt.Errorf("no role ARN in %s", output)
+}